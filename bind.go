@@ -0,0 +1,144 @@
+package nescript
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// structTagKey is the struct tag used to name fields when binding structs
+	// to template data, e.g. `nescript:"name"`.
+	structTagKey string = "nescript"
+)
+
+// StructFields converts a struct (or pointer to a struct) into a map of
+// template data. Every exported field is included, named by its `nescript`
+// struct tag if present (or its field name if not), whilst fields tagged with
+// `nescript:"-"` are skipped. Nested structs (and slices of structs) are
+// converted to nested maps, and the fields of embedded structs without a tag
+// are promoted into the parent. Values that implement fmt.Stringer or
+// encoding.TextMarshaler (such as net.IP or time.Time) are kept as-is.
+func StructFields(v any) (map[string]any, error) {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, fmt.Errorf("can not bind fields from a nil pointer")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can not bind fields from a %T, must be a struct", v)
+	}
+	return structToMap(value), nil
+}
+
+// structToMap converts the struct value into a map of its exported fields.
+func structToMap(value reflect.Value) map[string]any {
+	fields := make(map[string]any)
+	structType := value.Type()
+	for idx := 0; idx < structType.NumField(); idx++ {
+		field := structType.Field(idx)
+		tag, hasTag := field.Tag.Lookup(structTagKey)
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name := field.Name
+		if hasTag && tag != "" {
+			name = tag
+		}
+		fieldValue := value.Field(idx)
+		if field.Anonymous && !hasTag {
+			for fieldValue.Kind() == reflect.Pointer && !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				for k, v := range structToMap(fieldValue) {
+					if _, exists := fields[k]; !exists {
+						fields[k] = v
+					}
+				}
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+		}
+		fields[name] = bindValue(fieldValue)
+	}
+	return fields
+}
+
+// bindValue converts a field value for use as template data.
+func bindValue(value reflect.Value) any {
+	if !value.IsValid() {
+		return nil
+	}
+	if value.CanInterface() {
+		switch value.Interface().(type) {
+		case fmt.Stringer, encoding.TextMarshaler:
+			return value.Interface()
+		}
+	}
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return bindValue(value.Elem())
+	case reflect.Struct:
+		return structToMap(value)
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return value.Interface()
+		}
+		elemKind := value.Type().Elem().Kind()
+		if elemKind != reflect.Struct && elemKind != reflect.Pointer && elemKind != reflect.Interface {
+			return value.Interface()
+		}
+		items := make([]any, value.Len())
+		for idx := range items {
+			items[idx] = bindValue(value.Index(idx))
+		}
+		return items
+	}
+	return value.Interface()
+}
+
+// missingFields returns the given field paths (e.g. "node.addr") that can not
+// be found in the data. Paths that continue past a value that is not a map
+// (e.g. a method of the value) are assumed to be present.
+func missingFields(paths []string, data map[string]any) []string {
+	missing := make([]string, 0)
+	for _, path := range paths {
+		current := data
+		for _, key := range strings.Split(path, ".") {
+			value, ok := current[key]
+			if !ok {
+				missing = append(missing, path)
+				break
+			}
+			if current, ok = value.(map[string]any); !ok {
+				break
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// bindStruct adds the fields of the struct to the data, provided every field
+// referenced by the templates can then be found in the data.
+func (dd *dynamicData) bindStruct(v any, referenced []string) error {
+	fields, err := StructFields(v)
+	if err != nil {
+		return err
+	}
+	if missing := missingFields(referenced, dd.mergedData(fields)); len(missing) > 0 {
+		return fmt.Errorf("referenced fields are not set: %s", strings.Join(missing, ", "))
+	}
+	dd.addFields(fields, true)
+	return nil
+}
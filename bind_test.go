@@ -0,0 +1,143 @@
+package nescript
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type bindAddr struct {
+	Host string `nescript:"host"`
+	Port int    `nescript:"port"`
+}
+
+type bindBase struct {
+	User string `nescript:"user"`
+}
+
+type bindNode struct {
+	bindBase
+	Name     string     `nescript:"name"`
+	Addr     bindAddr   `nescript:"addr"`
+	Backup   *bindAddr  `nescript:"backup"`
+	Peers    []bindAddr `nescript:"peers"`
+	IP       net.IP     `nescript:"ip"`
+	Untagged string
+	Skipped  string `nescript:"-"`
+	secret   string
+}
+
+func TestStructFields(t *testing.T) {
+	node := bindNode{
+		bindBase: bindBase{User: "root"},
+		Name:     "n1",
+		Addr:     bindAddr{Host: "10.0.0.1", Port: 22},
+		Backup:   &bindAddr{Host: "10.0.0.2", Port: 2222},
+		Peers:    []bindAddr{{Host: "10.0.0.3", Port: 22}},
+		IP:       net.ParseIP("10.0.0.1"),
+		Untagged: "u",
+		Skipped:  "s",
+		secret:   "x",
+	}
+	expected := map[string]any{
+		"user":     "root",
+		"name":     "n1",
+		"addr":     map[string]any{"host": "10.0.0.1", "port": 22},
+		"backup":   map[string]any{"host": "10.0.0.2", "port": 2222},
+		"peers":    []any{map[string]any{"host": "10.0.0.3", "port": 22}},
+		"ip":       node.IP,
+		"Untagged": "u",
+	}
+	for name, v := range map[string]any{"struct": node, "pointer": &node} {
+		t.Run(name, func(t *testing.T) {
+			fields, err := StructFields(v)
+			if err != nil {
+				t.Fatalf("failed to get fields: %v", err)
+			}
+			if !reflect.DeepEqual(fields, expected) {
+				t.Errorf("expected fields:\n%#v\ngot:\n%#v", expected, fields)
+			}
+		})
+	}
+}
+
+func TestStructFieldsNilPointers(t *testing.T) {
+	fields, err := StructFields(&bindNode{})
+	if err != nil {
+		t.Fatalf("failed to get fields: %v", err)
+	}
+	if value, ok := fields["backup"]; !ok || value != nil {
+		t.Errorf("expected a nil pointer struct to be nil, got %#v", value)
+	}
+	var node *bindNode
+	if _, err := StructFields(node); err == nil {
+		t.Error("expected an error for a nil pointer")
+	}
+}
+
+func TestStructFieldsNotStruct(t *testing.T) {
+	for _, v := range []any{"a", 1, map[string]any{}, []bindAddr{}} {
+		if _, err := StructFields(v); err == nil {
+			t.Errorf("expected an error for a %T", v)
+		}
+	}
+}
+
+func TestWithStruct(t *testing.T) {
+	node := &bindNode{
+		Name:   "n1",
+		Addr:   bindAddr{Host: "10.0.0.1", Port: 22},
+		Backup: &bindAddr{Host: "10.0.0.2", Port: 2222},
+	}
+	script, err := NewScript("ssh -p {{.addr.port}} {{.addr.host}} || ssh -p {{.backup.port}} {{.backup.host}} # {{.name}}").WithStruct(node)
+	if err != nil {
+		t.Fatalf("failed to bind struct: %v", err)
+	}
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if expected := "ssh -p 22 10.0.0.1 || ssh -p 2222 10.0.0.2 # n1"; compiled.Raw() != expected {
+		t.Errorf("expected %q, got %q", expected, compiled.Raw())
+	}
+	cmd, err := NewCmd("ping", "{{.addr.host}}").WithStruct(*node)
+	if err != nil {
+		t.Fatalf("failed to bind struct to cmd: %v", err)
+	}
+	if compiledCmd, err := cmd.Compile(); err != nil || compiledCmd.Raw()[1] != "10.0.0.1" {
+		t.Errorf("unexpected cmd %q (%v)", compiledCmd.Raw(), err)
+	}
+}
+
+func TestWithStructMissingFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		missing string
+	}{
+		{"top level", "echo {{.hostname}}", "hostname"},
+		{"nested", "echo {{.addr.hostname}}", "addr.hostname"},
+		{"range root", "{{range .peers}}{{$.region}}{{end}}", "region"},
+		{"untagged by tag name", "echo {{.untagged}}", "untagged"},
+		{"skipped", "echo {{.Skipped}}", "Skipped"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewScript(test.text).WithStruct(bindNode{})
+			if err == nil || !strings.Contains(err.Error(), test.missing) {
+				t.Errorf("expected an error naming %q, got: %v", test.missing, err)
+			}
+		})
+	}
+}
+
+func TestWithStructFieldsFromElsewhere(t *testing.T) {
+	script, err := NewScript("echo {{.name}} {{.region}}").WithField("region", "eu").WithStruct(bindNode{Name: "n1"})
+	if err != nil {
+		t.Fatalf("expected fields set elsewhere to satisfy the struct: %v", err)
+	}
+	if compiled := script.MustCompile(); compiled.Raw() != "echo n1 eu" {
+		t.Errorf("unexpected script: %q", compiled.Raw())
+	}
+}
@@ -1,15 +1,23 @@
 package nescript
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
+	"io"
+	"text/template"
 )
 
 type Cmd struct {
-	command   string
-	args      []string
-	formatter Formatter
+	command string
+	args    []string
+
+	// formatter is the formatter set via WithFormatter (if any), otherwise
+	// defaultFormatter is used.
+	formatter        Formatter
+	defaultFormatter Formatter
+
+	options templateOptions
+	stdout  io.Writer
+	stderr  io.Writer
 	*dynamicData
 }
 
@@ -18,9 +26,9 @@ func NewCmd(command string, args ...string) *Cmd {
 		args = make([]string, 0)
 	}
 	cmd := Cmd{
-		command:   command,
-		args:      args,
-		formatter: defaultCmdFormatter,
+		command:          command,
+		args:             args,
+		defaultFormatter: defaultCmdFormatter,
 		dynamicData: &dynamicData{
 			data: make(map[string]any),
 			env:  make([]string, 0),
@@ -29,8 +37,40 @@ func NewCmd(command string, args ...string) *Cmd {
 	return &cmd
 }
 
+// NewCmdFromString creates a Cmd by splitting the given string into the
+// command and its arguments, as a POSIX shell would. Single quotes, double
+// quotes and backslash escapes are handled, and any env var assignments before
+// the command (e.g. "LANG=C sort file") are added as env vars of the Cmd. No
+// expansion is performed, thus this errors if the string uses parameter
+// expansion, command substitution, $'...' quoting, operators (such as pipes,
+// redirections or lists) or unquoted newlines, rather than executing something
+// other than what was intended.
+// Template actions are kept as-is, so can be compiled as usual.
+func NewCmdFromString(s string) (*Cmd, error) {
+	words, err := splitCommand(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command string: %w", err)
+	}
+	env := make([]string, 0)
+	for len(words) > 0 && words[0].isAssignment() {
+		env = append(env, words[0].text)
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("failed to parse command string: no command given")
+	}
+	args := make([]string, len(words)-1)
+	for idx, word := range words[1:] {
+		args[idx] = word.text
+	}
+	cmd := NewCmd(words[0].text, args...)
+	cmd.addEnv(env...)
+	return cmd, nil
+}
+
 // Raw returns the command split by its arguments in its current state. If not
-// compiled, handlebar values will still be present.
+// compiled, handlebar values will still be present. Secret values are not
+// masked (see String).
 func (c Cmd) Raw() []string {
 	return append([]string{c.command}, c.args...)
 }
@@ -54,6 +94,22 @@ func (c Cmd) WithArgs(args ...string) Cmd {
 	return c
 }
 
+// WithPrefix places the given args before the command, such that the first
+// prefix arg becomes the executable and the current command (and its
+// arguments) are appended to the remaining prefix args. For example, the
+// command ["ls", "-l"] with prefix ["sudo", "-n"] becomes ["sudo", "-n", "ls",
+// "-l"]. The template data, env vars and formatter of the command are kept.
+func (c Cmd) WithPrefix(prefix ...string) Cmd {
+	if len(prefix) == 0 {
+		return c
+	}
+	args := append([]string{}, prefix[1:]...)
+	args = append(args, c.command)
+	c.args = append(args, c.args...)
+	c.command = prefix[0]
+	return c
+}
+
 // WithField adds a key/value to the map of template data to be used when
 // compiling the command. If the key already exists, it is overwritten.
 func (c Cmd) WithField(key string, value any) Cmd {
@@ -69,6 +125,33 @@ func (c Cmd) WithFields(fields map[string]any, overwrite bool) Cmd {
 	return c
 }
 
+// WithStruct adds the exported fields of the struct (or pointer to a struct) to
+// the map of template data, replacing any existing keys (see StructFields for
+// how fields are named). This errors, leaving the data unchanged, if v is not a
+// struct, or if any field referenced by the command (see Fields) would not be
+// set.
+func (c Cmd) WithStruct(v any) (Cmd, error) {
+	referenced, err := c.Fields()
+	if err != nil {
+		return c, err
+	}
+	if err := c.bindStruct(v, referenced); err != nil {
+		return c, fmt.Errorf("failed to bind struct to cmd: %w", err)
+	}
+	return c, nil
+}
+
+// WithSecretField adds a key/value to the map of template data (as with
+// WithField), marking the value as secret. Secret values are still used when
+// compiling the command, however are masked by String, and in the output and
+// errors of processes it is executed as. Only strings can be secret, as the
+// formatted forms of other values (such as a port number) are likely to appear
+// in output that is not secret.
+func (c Cmd) WithSecretField(key string, value string) Cmd {
+	c.addSecretField(key, value)
+	return c
+}
+
 // WithEnv takes one or more environmental variables in KEY=VALUE format. These
 // will be used when executing the command. These will not be applied to the
 // actual arguments of the command, but to any subprocess spawned by the
@@ -78,6 +161,13 @@ func (c Cmd) WithEnv(env ...string) Cmd {
 	return c
 }
 
+// WithSecretEnv takes one or more environmental variables in KEY=VALUE format
+// (as with WithEnv), marking their values as secret (see WithSecretField).
+func (c Cmd) WithSecretEnv(env ...string) Cmd {
+	c.addSecretEnv(env...)
+	return c
+}
+
 // WithLocalOSEnv appends the environmental variables from the local system to
 // the env var set currently held be the command.
 func (c Cmd) WithLocalOSEnv() Cmd {
@@ -85,33 +175,126 @@ func (c Cmd) WithLocalOSEnv() Cmd {
 	return c
 }
 
+// WithFuncs adds functions that can be used by the command's template, in
+// addition to the builtin and default functions (see DefaultFuncs). Functions
+// with the same name as an existing function replace it.
+func (c Cmd) WithFuncs(funcs template.FuncMap) Cmd {
+	c.options = c.options.withFuncs(funcs)
+	return c
+}
+
+// WithStrict sets whether compiling the command should error if its template
+// references a field that has not been set. By default, missing fields are
+// replaced with "<no value>".
+func (c Cmd) WithStrict(strict bool) Cmd {
+	c.options.strict = strict
+	return c
+}
+
+// WithLibrary sets the library of templates that the command can refer to by
+// name, via the template action or the include function (see ScriptLibrary).
+func (c Cmd) WithLibrary(library *ScriptLibrary) Cmd {
+	c.options.library = library
+	return c
+}
+
+// WithEscaping sets how values are escaped when inserted into the command's
+// arguments when it is compiled. Each argument is escaped independently, thus
+// ShellEscaping is only appropriate for arguments that are interpreted by a
+// POSIX shell, such as the script passed to ["sh", "-c"].
+func (c Cmd) WithEscaping(escaping Escaping) Cmd {
+	c.options.escaping = escaping
+	return c
+}
+
+// WithFormatter sets the formatter used to convert the command into a single
+// string (see String), including when executed via a shell (see
+// ShellFormatted).
 func (c Cmd) WithFormatter(formatter Formatter) Cmd {
 	c.formatter = formatter
 	return c
 }
 
+// WithOutput sets writers that the stdout and stderr of the process are written
+// to as the command executes (in addition to being captured in the result),
+// for example to display the output live. Either writer can be nil. This is
+// supported by the local, sshe and docker executors. Secret values are masked
+// in what is written, unless a value is split across separate writes.
+func (c Cmd) WithOutput(stdout, stderr io.Writer) Cmd {
+	c.stdout = stdout
+	c.stderr = stderr
+	return c
+}
+
+// Output returns the stdout and stderr writers set by WithOutput (if any).
+func (c Cmd) Output() (stdout, stderr io.Writer) {
+	return c.stdout, c.stderr
+}
+
+// StdoutWriter returns a writer that writes to the given buffer, as well as to
+// the stdout writer set by WithOutput (if any). This is intended for use by
+// executors, so that the stdout of the process is both captured and streamed.
+// Errors writing to the stdout writer are ignored.
+func (c Cmd) StdoutWriter(buffer io.Writer) io.Writer {
+	return c.teeOutput(buffer, c.stdout)
+}
+
+// StderrWriter returns a writer that writes to the given buffer, as well as to
+// the stderr writer set by WithOutput (if any) (see StdoutWriter).
+func (c Cmd) StderrWriter(buffer io.Writer) io.Writer {
+	return c.teeOutput(buffer, c.stderr)
+}
+
+// teeOutput returns a writer that writes to the buffer and the live writer.
+func (c Cmd) teeOutput(buffer, live io.Writer) io.Writer {
+	if live == nil {
+		return buffer
+	}
+	var secrets []string
+	if c.dynamicData != nil {
+		secrets = c.secrets
+	}
+	return io.MultiWriter(buffer, liveWriter{
+		writer:  live,
+		secrets: secrets,
+	})
+}
+
+// liveWriter writes to the underlying writer with secrets masked, ignoring
+// any errors so that the output of a process is always captured.
+type liveWriter struct {
+	writer  io.Writer
+	secrets []string
+}
+
+func (lw liveWriter) Write(p []byte) (int, error) {
+	lw.writer.Write([]byte(redact(string(p), lw.secrets)))
+	return len(p), nil
+}
+
 // Compile uses the go template engine and the provided data fields to compile
 // the command. These in-turn act a more portable approach than command-line
-// arguments.
+// arguments. The returned command has no data and is not templated again if
+// compiled again, whilst the command it was compiled from is left unchanged,
+// so can be compiled again (see Template to avoid re-parsing the templates
+// each time).
 func (c Cmd) Compile() (Cmd, error) {
-	compiledArgs := make([]string, len(c.args))
-	for idx, a := range c.args {
-		argTemplate, err := template.New("").Parse(a)
-		if err != nil {
-			return c, fmt.Errorf("failed to parse a command arg: %w", err)
-		}
-		if c.data == nil {
-			c.data = make(map[string]any)
-		}
-		compiledArg := &bytes.Buffer{}
-		if err := argTemplate.Execute(compiledArg, c.data); err != nil {
-			return c, fmt.Errorf("cmd arg template could not be compiled: %w", err)
-		}
-		compiledArgs[idx] = compiledArg.String()
-	}
-	c.args = compiledArgs
-	c.data = make(map[string]any)
-	return c, nil
+	cmdTemplate, err := c.Template()
+	if err != nil {
+		return c, err
+	}
+	compiled, err := cmdTemplate.Render(nil)
+	if err != nil {
+		return c, err
+	}
+	return compiled, nil
+}
+
+// Fields returns the sorted paths of every field referenced by the templates
+// of the command's arguments, relative to the template data (see
+// Script.Fields). This errors if any argument's template can not be parsed.
+func (c Cmd) Fields() ([]string, error) {
+	return c.options.fields(c.args...)
 }
 
 // MustCompile compiles the command, however will panic if an error occurred.
@@ -0,0 +1,350 @@
+// Command nescript executes scripts and commands on local or remote targets,
+// printing their output live and reporting any outputs they set as JSON.
+//
+// Usage:
+//
+//	nescript run [flags] <script-file>
+//	nescript run [flags] -c '<inline script>'
+//
+// Run "nescript run -h" for the available flags.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/willfantom/nescript"
+	"github.com/willfantom/nescript/expr"
+	"github.com/willfantom/nescript/target"
+)
+
+const (
+	// exitError is the exit code used when nescript itself fails, such as when
+	// given invalid flags or the target can not be reached. As with env and
+	// docker run, 125 is used as it is rarely used by scripts themselves.
+	exitError int = 125
+
+	// exitExpectFailed is the exit code used when the expected expression does
+	// not hold, or the outputs declared by the script's metadata are not set.
+	exitExpectFailed int = 1
+)
+
+// keyValues is a flag that can be given many times, each as KEY=VALUE.
+type keyValues []string
+
+func (kv *keyValues) String() string {
+	return strings.Join(*kv, ",")
+}
+
+func (kv *keyValues) Set(value string) error {
+	if !strings.Contains(value, "=") || strings.HasPrefix(value, "=") {
+		return fmt.Errorf("'%s' is not in KEY=VALUE format", value)
+	}
+	*kv = append(*kv, value)
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the nescript command with the given args, returning the exit
+// code.
+func run(args []string, stdout, stderr io.Writer) int {
+	usage := func() {
+		fmt.Fprintf(stderr, "usage: nescript run [flags] <script-file>\n")
+		fmt.Fprintf(stderr, "       nescript run [flags] -c '<inline script>'\n")
+	}
+	if len(args) == 0 {
+		usage()
+		return exitError
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		usage()
+		return 0
+	case "run":
+		return runCommand(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "nescript: unknown command '%s'\n", args[0])
+		usage()
+		return exitError
+	}
+}
+
+// runCommand implements "nescript run".
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	var (
+		fields     keyValues
+		secrets    keyValues
+		env        keyValues
+		secretEnv  keyValues
+		inline     string
+		targetURI  string
+		subcommand string
+		expect     string
+		outputPath string
+		timeout    time.Duration
+		strict     bool
+		escape     bool
+		quiet      bool
+	)
+	flags := flag.NewFlagSet("nescript run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&fields, "field", "template field in KEY=VALUE format, where the value is converted to the type of the script's input of the same name (or kept as a string), or KEY:=VALUE where the value is parsed as JSON (can be repeated)")
+	flags.Var(&secrets, "secret-field", "as -field, but the value (always a string) is masked in any output (can be repeated)")
+	flags.Var(&env, "env", "env var in KEY=VALUE format (can be repeated)")
+	flags.Var(&secretEnv, "secret-env", "as -env, but the value is masked in any output (can be repeated)")
+	flags.StringVar(&inline, "c", "", "inline script to execute, rather than a script file")
+	flags.StringVar(&targetURI, "target", "local://", "URI of the target to execute on, e.g. ssh://user@host:22 or docker://container")
+	flags.StringVar(&subcommand, "subcommand", "", "subcommand the script is passed to, e.g. 'bash -c' (default from the script's metadata, or 'sh -c')")
+	flags.StringVar(&expect, "expect", "", "expression evaluated against the outputs, where the exit code is 0 if it holds and 1 if not")
+	flags.StringVar(&outputPath, "outputs", "-", "file the outputs are written to as JSON, or - for stdout")
+	flags.DurationVar(&timeout, "timeout", 0, "maximum time to wait for the script, after which it is killed (default from the script's metadata, or none)")
+	flags.BoolVar(&strict, "strict", false, "error if the script references a field that has not been set")
+	flags.BoolVar(&escape, "escape", false, "shell escape field values inserted into the script")
+	flags.BoolVar(&quiet, "quiet", false, "do not print the stdout/stderr of the script as it executes")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: nescript run [flags] <script-file>\n")
+		fmt.Fprintf(stderr, "       nescript run [flags] -c '<inline script>'\n\nflags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return exitError
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(stderr, "nescript: %v\n", err)
+		return exitError
+	}
+
+	script, err := loadScript(inline, flags.Args())
+	if err != nil {
+		return fail(err)
+	}
+	if subcommand != "" {
+		*script = script.WithSubcommand(strings.Fields(subcommand))
+	}
+	var inputs map[string]nescript.InputSpec
+	if script.Metadata() != nil {
+		inputs = script.Metadata().Inputs
+	}
+	for _, field := range fields {
+		key, value, err := parseField(field, inputs)
+		if err != nil {
+			return fail(err)
+		}
+		*script = script.WithField(key, value)
+	}
+	for _, field := range secrets {
+		key, value, _ := strings.Cut(field, "=")
+		if strings.HasSuffix(key, ":") {
+			return fail(fmt.Errorf("secret field '%s' can only be a string", strings.TrimSuffix(key, ":")))
+		}
+		*script = script.WithSecretField(key, value)
+	}
+	*script = script.WithEnv(env...).WithSecretEnv(secretEnv...).WithStrict(strict)
+	if escape {
+		*script = script.WithEscaping(nescript.ShellEscaping)
+	}
+	if timeout == 0 && script.Metadata() != nil {
+		timeout = script.Metadata().Timeout
+	}
+
+	executor, err := target.Executor(targetURI)
+	if err != nil {
+		return fail(err)
+	}
+	compiled, err := script.Compile()
+	if err != nil {
+		return fail(err)
+	}
+	cmd := compiled.Cmd()
+	if !quiet {
+		cmd = cmd.WithOutput(stdout, stderr)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	result, err := execute(ctx, cmd, executor, timeout)
+	if err != nil {
+		return fail(err)
+	}
+
+	outputs := result.CombinedOutput()
+	if err := writeOutputs(outputs, outputPath, stdout); err != nil {
+		return fail(err)
+	}
+	if script.Metadata() != nil {
+		if err := script.Metadata().ValidateOutput(outputs); err != nil {
+			fmt.Fprintf(stderr, "nescript: %v\n", err)
+			if result.ExitCode != 0 {
+				return result.ExitCode
+			}
+			return exitExpectFailed
+		}
+	}
+	if expect == "" {
+		return result.ExitCode
+	}
+	ok, err := outputs.Evaluate(expr.EvalFunc(), expect)
+	if err != nil {
+		return fail(err)
+	}
+	if !ok {
+		fmt.Fprintf(stderr, "nescript: expectation '%s' does not hold\n", expect)
+		return exitExpectFailed
+	}
+	return 0
+}
+
+// loadScript creates the script from the inline script, or the script file
+// given as the only positional arg.
+func loadScript(inline string, positional []string) (*nescript.Script, error) {
+	if inline != "" {
+		if len(positional) > 0 {
+			return nil, fmt.Errorf("a script file can not be given along with -c")
+		}
+		return nescript.ParseScript(inline)
+	}
+	if len(positional) != 1 {
+		return nil, fmt.Errorf("expected exactly one script file, or -c")
+	}
+	return nescript.NewScriptFromFile(positional[0])
+}
+
+// parseField splits the KEY=VALUE field. If the script declares an input of
+// the same name with a type (see nescript.InputSpec), the value is converted to
+// that type, where lists and maps are given as JSON. Otherwise, the value is
+// used as a string. If given as KEY:=VALUE, the value is instead parsed as JSON
+// so that numbers, bools, lists and maps can be given, where whole numbers are
+// ints. This errors if the value can not be converted, or a KEY:=VALUE value
+// is not valid JSON.
+func parseField(field string, inputs map[string]nescript.InputSpec) (string, any, error) {
+	key, raw, _ := strings.Cut(field, "=")
+	if !strings.HasSuffix(key, ":") {
+		value, err := convertField(key, raw, inputs[key].Type)
+		return key, value, err
+	}
+	key = strings.TrimSuffix(key, ":")
+	if key == "" {
+		return key, nil, fmt.Errorf("'%s' is not in KEY:=VALUE format", field)
+	}
+	value, err := parseJSON(key, raw)
+	return key, value, err
+}
+
+// convertField converts the value of the field to the given input type, or
+// keeps it as a string if the type is string or empty.
+func convertField(key, raw, inputType string) (any, error) {
+	switch strings.ToLower(inputType) {
+	case "int":
+		value, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("value of field '%s' is not an int: '%s'", key, raw)
+		}
+		return value, nil
+	case "float":
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value of field '%s' is not a float: '%s'", key, raw)
+		}
+		return value, nil
+	case "bool":
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("value of field '%s' is not a bool: '%s'", key, raw)
+		}
+		return value, nil
+	case "list", "map":
+		return parseJSON(key, raw)
+	}
+	return raw, nil
+}
+
+// parseJSON parses the value of the field as a single JSON value, where whole
+// numbers are ints.
+func parseJSON(key, raw string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("value of field '%s' is not valid JSON: %w", key, err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("value of field '%s' is not a single JSON value", key)
+	}
+	return jsonNumbers(value), nil
+}
+
+// jsonNumbers replaces the JSON numbers within the decoded value with ints, or
+// floats if they are not whole numbers.
+func jsonNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for idx := range v {
+			v[idx] = jsonNumbers(v[idx])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = jsonNumbers(v[k])
+		}
+	}
+	return value
+}
+
+// execute runs the cmd, waiting for its result. If the timeout (when not 0) is
+// reached, or the context is cancelled (e.g. by an interrupt), the process is
+// killed.
+func execute(ctx context.Context, cmd nescript.Cmd, executor nescript.ExecFunc, timeout time.Duration) (*nescript.Result, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	process, err := cmd.Exec(executor)
+	if err != nil {
+		return nil, err
+	}
+	defer process.Close()
+	result, err := nescript.WaitContext(ctx, process)
+	if err != nil {
+		return nil, fmt.Errorf("script did not complete: %w", err)
+	}
+	return result, nil
+}
+
+// writeOutputs writes the outputs as JSON to the file at the path, or the
+// given writer if the path is "-".
+func writeOutputs(outputs nescript.Output, path string, stdout io.Writer) error {
+	outputBytes, err := json.MarshalIndent(outputs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode outputs: %w", err)
+	}
+	outputBytes = append(outputBytes, '\n')
+	if path == "-" {
+		_, err = stdout.Write(outputBytes)
+		return err
+	}
+	if err := os.WriteFile(path, outputBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write outputs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field string
+		key   string
+		value any
+	}{
+		{"version=1.10", "version", "1.10"},
+		{"mode=0755", "mode", "0755"},
+		{"enabled=no", "enabled", "no"},
+		{"empty=", "empty", ""},
+		{"url=http://a?b=c", "url", "http://a?b=c"},
+		{"count:=3", "count", 3},
+		{"ratio:=1.5", "ratio", 1.5},
+		{"debug:=true", "debug", true},
+		{"name:=\"1.10\"", "name", "1.10"},
+		{`hosts:=["a", 2]`, "hosts", []any{"a", 2}},
+		{`limits:={"cpu": 2}`, "limits", map[string]any{"cpu": 2}},
+	}
+	for _, test := range tests {
+		key, value, err := parseField(test.field, nil)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %v", test.field, err)
+			continue
+		}
+		if key != test.key || !reflect.DeepEqual(value, test.value) {
+			t.Errorf("'%s' parsed as %s=%#v", test.field, key, value)
+		}
+	}
+	for _, field := range []string{"mode:=0755", "enabled:=no", ":=3", "list:=[1] [2]"} {
+		if _, _, err := parseField(field, nil); err == nil {
+			t.Errorf("expected '%s' to be rejected", field)
+		}
+	}
+}
+
+func TestParseFieldInputTypes(t *testing.T) {
+	inputs := map[string]nescript.InputSpec{
+		"name":    {Type: "string"},
+		"any":     {},
+		"count":   {Type: "int"},
+		"ratio":   {Type: "float"},
+		"debug":   {Type: "bool"},
+		"hosts":   {Type: "list"},
+		"limits":  {Type: "map"},
+		"upper":   {Type: "INT"},
+		"version": {Type: "string"},
+	}
+	tests := []struct {
+		field string
+		value any
+	}{
+		{"name=0755", "0755"},
+		{"any=3", "3"},
+		{"undeclared=3", "3"},
+		{"count=3", 3},
+		{"count=-3", -3},
+		{"ratio=1.5", 1.5},
+		{"ratio=2", 2.0},
+		{"debug=true", true},
+		{"debug=0", false},
+		{`hosts=["a", 2]`, []any{"a", 2}},
+		{`limits={"cpu": 2}`, map[string]any{"cpu": 2}},
+		{"upper=7", 7},
+		{"version:=1.5", 1.5},
+	}
+	for _, test := range tests {
+		_, value, err := parseField(test.field, inputs)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %v", test.field, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, test.value) {
+			t.Errorf("'%s' parsed as %#v, expected %#v", test.field, value, test.value)
+		}
+	}
+	for _, field := range []string{"count=3.5", "count=three", "ratio=fast", "debug=maybe", "hosts=a,b", "limits={cpu: 2}"} {
+		if _, _, err := parseField(field, inputs); err == nil {
+			t.Errorf("expected '%s' to be rejected", field)
+		}
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	const typedScript string = "# ---\n# inputs:\n#   count: {type: int, required: true}\n# ---\ntest {{.count}} -eq 3"
+	const outputScript string = "# ---\n# outputs:\n#   loss: {type: int}\n# ---\n"
+	tests := map[string]struct {
+		args []string
+		code int
+	}{
+		"script exit code": {[]string{"run", "-quiet", "-c", "exit 2"}, 2},
+		"invalid flag":     {[]string{"run", "-unknown"}, exitError},
+		"invalid field":    {[]string{"run", "-field", "x:=no", "-c", "true"}, exitError},
+		"typed secret":     {[]string{"run", "-secret-field", "x:=22", "-c", "true"}, exitError},
+		"unknown command":  {[]string{"walk"}, exitError},
+		"no command":       {[]string{}, exitError},
+		"help":             {[]string{"-h"}, 0},
+		"long help":        {[]string{"--help"}, 0},
+		"run help":         {[]string{"run", "-h"}, 0},
+		"typed input":      {[]string{"run", "-quiet", "-field", "count=3", "-c", typedScript}, 0},
+		"invalid input":    {[]string{"run", "-quiet", "-field", "count=three", "-c", typedScript}, exitError},
+		"outputs set":      {[]string{"run", "-quiet", "-c", outputScript + `echo "::set-output name=loss type=int::0"`}, 0},
+		"outputs not set":  {[]string{"run", "-quiet", "-c", outputScript + "true"}, exitExpectFailed},
+		"outputs invalid":  {[]string{"run", "-quiet", "-c", outputScript + `echo "::set-output name=loss::none"`}, exitExpectFailed},
+		"script failed":    {[]string{"run", "-quiet", "-c", outputScript + "exit 3"}, 3},
+		"expect holds":     {[]string{"run", "-quiet", "-expect", "loss == 0", "-c", outputScript + `echo "::set-output name=loss type=int::0"`}, 0},
+		"expect fails":     {[]string{"run", "-quiet", "-expect", "loss > 0", "-c", outputScript + `echo "::set-output name=loss type=int::0"`}, exitExpectFailed},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if code := run(test.args, io.Discard, io.Discard); code != test.code {
+				t.Errorf("exit code is %d, expected %d", code, test.code)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package nescript
 
-import "os"
+import (
+	"os"
+	"strings"
+)
 
 type dynamicData struct {
-	data map[string]any
-	env  []string
+	data    map[string]any
+	env     []string
+	secrets []string
 }
 
 // Data returns the map of template data to be used when compiling the
@@ -19,6 +23,19 @@ func (dd dynamicData) Env() []string {
 	return dd.env
 }
 
+// Secrets returns the values of any fields or env vars that have been marked as
+// secret. These are masked wherever the script/cmd is formatted or logged, and
+// in the output of processes it is executed as.
+func (dd dynamicData) Secrets() []string {
+	return dd.secrets
+}
+
+// Redact returns the given string with every secret value of the script/cmd
+// replaced with a mask.
+func (dd dynamicData) Redact(s string) string {
+	return redact(s, dd.secrets)
+}
+
 func (dd *dynamicData) addField(key string, value any) {
 	if dd.data == nil {
 		dd.data = make(map[string]any)
@@ -44,6 +61,70 @@ func (dd *dynamicData) addEnv(env ...string) {
 	dd.env = append(dd.env, env...)
 }
 
+// addSecretField adds the field, marking its value as secret. The shell
+// escaped forms of the value are also marked, as they are what appear in
+// scripts/cmds compiled with ShellEscaping.
+func (dd *dynamicData) addSecretField(key, secret string) {
+	dd.addSecrets(secret, ShellQuote(secret), shellEscapeSingleQuoted(secret), shellEscapeDoubleQuoted(secret), shellEscapeANSIQuoted(secret), shellEscapeHeredoc(secret))
+	dd.addField(key, secret)
+}
+
+// addSecretEnv adds the env vars, marking their values as secret.
+func (dd *dynamicData) addSecretEnv(env ...string) {
+	for _, e := range env {
+		if _, value, ok := strings.Cut(e, "="); ok {
+			dd.addSecrets(value)
+		}
+	}
+	dd.addEnv(env...)
+}
+
+func (dd *dynamicData) addSecrets(secrets ...string) {
+	for _, secret := range secrets {
+		if secret != "" && !dd.isSecret(secret) {
+			dd.secrets = append(dd.secrets, secret)
+		}
+	}
+}
+
+func (dd *dynamicData) isSecret(value string) bool {
+	for _, secret := range dd.secrets {
+		if secret == value {
+			return true
+		}
+	}
+	return false
+}
+
 func (dd *dynamicData) addLocalOSEnv() {
 	dd.addEnv(os.Environ()...)
 }
+
+// mergedData returns a new map containing the current data, with the given
+// data added (replacing any existing keys).
+func (dd *dynamicData) mergedData(data map[string]any) map[string]any {
+	merged := make(map[string]any)
+	if dd != nil {
+		for k, v := range dd.data {
+			merged[k] = v
+		}
+	}
+	for k, v := range data {
+		merged[k] = v
+	}
+	return merged
+}
+
+// compiled returns new dynamic data for a compiled script/cmd, which has the
+// same env vars and secrets, but no template data.
+func (dd *dynamicData) compiled() *dynamicData {
+	compiled := dynamicData{
+		data: make(map[string]any),
+		env:  make([]string, 0),
+	}
+	if dd != nil {
+		compiled.env = append(compiled.env, dd.env...)
+		compiled.secrets = append(compiled.secrets, dd.secrets...)
+	}
+	return &compiled
+}
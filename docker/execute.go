@@ -16,9 +16,10 @@ import (
 // Optionally, a WorkDir may be set, setting the precess working directory (path
 // should be in the context of the container's file system). This ExecFunc does
 // not require that the cmd/script be converted to a string, so is Formatter
-// agnostic.
+// agnostic. Secret values of the cmd are masked in the output of the process
+// and any errors.
 func Executor(client *docker.Client, containerID, workdir string) nescript.ExecFunc {
-	return func(c nescript.Cmd) (nescript.Process, error) {
+	return nescript.RedactionMiddleware()(func(c nescript.Cmd) (nescript.Process, error) {
 		config := types.ExecConfig{
 			Tty:          false,
 			AttachStdin:  true,
@@ -42,7 +43,7 @@ func Executor(client *docker.Client, containerID, workdir string) nescript.ExecF
 		} else {
 			process.dockerConn = &conn
 			go func() {
-				_, err := stdcopy.StdCopy(&process.stdoutBytes, &process.stderrBytes, conn.Reader)
+				_, err := stdcopy.StdCopy(c.StdoutWriter(&process.stdoutBytes), c.StderrWriter(&process.stderrBytes), conn.Reader)
 				process.complete <- err
 			}()
 		}
@@ -51,5 +52,5 @@ func Executor(client *docker.Client, containerID, workdir string) nescript.ExecF
 		}
 		return &process, nil
 
-	}
+	})
 }
@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/docker/docker/api/types"
+	docker "github.com/docker/docker/client"
+	"github.com/willfantom/nescript"
+)
+
+// DockerMaterialiser writes and removes files in a docker container.
+type DockerMaterialiser struct {
+	dockerClient *docker.Client
+	containerID  string
+}
+
+// Materialiser returns a Materialiser for the container with the given ID,
+// allowing for scripts to be executed as files by the docker executor (see
+// nescript.Script.ExecFile). Files are written by copying a tar archive into
+// the container, and removed by executing rm in the container.
+func Materialiser(client *docker.Client, containerID string) nescript.Materialiser {
+	return &DockerMaterialiser{
+		dockerClient: client,
+		containerID:  containerID,
+	}
+}
+
+func (m *DockerMaterialiser) WriteFile(filePath string, content []byte, perm os.FileMode) error {
+	archive := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(archive)
+	header := &tar.Header{
+		Name: path.Base(filePath),
+		Mode: int64(perm.Perm()),
+		Size: int64(len(content)),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	if err := m.dockerClient.CopyToContainer(context.Background(), m.containerID, path.Dir(filePath), archive, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy file to container '%s': %w", m.containerID, err)
+	}
+	return nil
+}
+
+func (m *DockerMaterialiser) Remove(filePath string) error {
+	process, err := nescript.NewCmd("rm", "-f", filePath).Exec(Executor(m.dockerClient, m.containerID, ""))
+	if err != nil {
+		return err
+	}
+	result, err := process.Result()
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("failed to remove file from container '%s': %s", m.containerID, result.StdErr)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	docker "github.com/docker/docker/client"
+)
+
+func TestMaterialiserWriteFile(t *testing.T) {
+	type copied struct {
+		path, name, content string
+		mode                int64
+	}
+	var files []copied
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasSuffix(r.URL.Path, "/containers/abc/archive") {
+			http.Error(w, "not implemented", http.StatusNotImplemented)
+			return
+		}
+		reader := tar.NewReader(r.Body)
+		for {
+			header, err := reader.Next()
+			if err != nil {
+				break
+			}
+			content, _ := io.ReadAll(reader)
+			files = append(files, copied{r.URL.Query().Get("path"), header.Name, string(content), header.Mode})
+		}
+	}))
+	defer server.Close()
+	client, err := docker.NewClientWithOpts(docker.WithHost("tcp://"+server.Listener.Addr().String()), docker.WithVersion("1.41"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := Materialiser(client, "abc").WriteFile("/tmp/work/nescript-1", []byte("#!/bin/sh\necho hi\n"), 0700); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file to be copied, got %d", len(files))
+	}
+	if file := files[0]; file.path != "/tmp/work" || file.name != "nescript-1" || file.content != "#!/bin/sh\necho hi\n" || file.mode != 0700 {
+		t.Errorf("unexpected file copied: %+v", file)
+	}
+	if err := Materialiser(client, "missing").WriteFile("/tmp/x", nil, 0700); err == nil {
+		t.Error("expected an error copying to a missing container")
+	}
+}
@@ -0,0 +1,195 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	docker "github.com/docker/docker/client"
+	"github.com/willfantom/nescript"
+)
+
+const (
+	composeProjectLabel string = "com.docker.compose.project"
+	composeServiceLabel string = "com.docker.compose.service"
+)
+
+// Selector describes how to find a single container. Any combination of the
+// fields can be set, where a container must match all of them. The name must
+// match the container's name exactly (with or without the leading slash).
+type Selector struct {
+	Name           string
+	Labels         map[string]string
+	ComposeProject string
+	ComposeService string
+}
+
+// WaitCondition is the state a container must reach before it is considered
+// resolved.
+type WaitCondition string
+
+const (
+	WaitNone    WaitCondition = ""
+	WaitRunning WaitCondition = "running"
+	WaitHealthy WaitCondition = "healthy"
+)
+
+var (
+	// ResolvePollInterval is the time between checks when waiting for a
+	// container to exist or reach a wait condition.
+	ResolvePollInterval time.Duration = 500 * time.Millisecond
+
+	// ErrNoContainer is returned (wrapped) by Resolve when no container
+	// matches the selector.
+	ErrNoContainer error = errors.New("no container matches selector")
+)
+
+// String returns a human readable description of the selector.
+func (s Selector) String() string {
+	parts := make([]string, 0)
+	if s.Name != "" {
+		parts = append(parts, "name="+s.Name)
+	}
+	if s.ComposeProject != "" {
+		parts = append(parts, "project="+s.ComposeProject)
+	}
+	if s.ComposeService != "" {
+		parts = append(parts, "service="+s.ComposeService)
+	}
+	labels := make([]string, 0, len(s.Labels))
+	for k, v := range s.Labels {
+		labels = append(labels, fmt.Sprintf("label=%s=%s", k, v))
+	}
+	sort.Strings(labels)
+	return strings.Join(append(parts, labels...), ",")
+}
+
+// filters converts the selector into docker API list filters.
+func (s Selector) filters() filters.Args {
+	args := filters.NewArgs()
+	if s.Name != "" {
+		args.Add("name", s.Name)
+	}
+	for k, v := range s.Labels {
+		args.Add("label", fmt.Sprintf("%s=%s", k, v))
+	}
+	if s.ComposeProject != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", composeProjectLabel, s.ComposeProject))
+	}
+	if s.ComposeService != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", composeServiceLabel, s.ComposeService))
+	}
+	return args
+}
+
+// Resolve finds the ID of the single container matching the selector. This
+// will error if no containers (see ErrNoContainer), or more than one
+// container, match.
+func Resolve(ctx context.Context, client *docker.Client, selector Selector) (string, error) {
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: selector.filters(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list docker containers: %w", err)
+	}
+	matches := make([]string, 0)
+	for _, container := range containers {
+		if selector.Name != "" && !hasName(container, selector.Name) {
+			continue
+		}
+		matches = append(matches, container.ID)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%w '%s'", ErrNoContainer, selector)
+	} else if len(matches) > 1 {
+		return "", fmt.Errorf("%d containers match selector '%s'", len(matches), selector)
+	}
+	return matches[0], nil
+}
+
+// Wait blocks until the container with the given ID reaches the wait
+// condition, or the context is done. Waiting for a container to be healthy
+// will error if the container does not have a healthcheck.
+func Wait(ctx context.Context, client *docker.Client, containerID string, condition WaitCondition) error {
+	for {
+		ready, err := reached(ctx, client, containerID, condition)
+		if err != nil {
+			return err
+		} else if ready {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("container '%s' did not become %s: %w", containerID, condition, ctx.Err())
+		case <-time.After(ResolvePollInterval):
+		}
+	}
+}
+
+// ResolvedExecutor finds the container matching the selector, waiting for it
+// to exist and reach the wait condition, then returns an ExecFunc for it (see
+// Executor). The context bounds how long to wait, thus should usually have a
+// deadline. Only the absence of a matching container is retried, whereas any
+// other error (such as multiple containers matching) is returned immediately.
+// The container is only resolved once, so if it is re-created, the executor
+// must be resolved again.
+func ResolvedExecutor(ctx context.Context, client *docker.Client, selector Selector, condition WaitCondition, workdir string) (nescript.ExecFunc, error) {
+	for {
+		containerID, err := Resolve(ctx, client, selector)
+		if err == nil {
+			if err := Wait(ctx, client, containerID, condition); err != nil {
+				return nil, err
+			}
+			return Executor(client, containerID, workdir), nil
+		} else if !errors.Is(err, ErrNoContainer) {
+			return nil, fmt.Errorf("failed to resolve container: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to resolve container: %w", err)
+		case <-time.After(ResolvePollInterval):
+		}
+	}
+}
+
+// reached determines if the container currently meets the wait condition.
+func reached(ctx context.Context, client *docker.Client, containerID string, condition WaitCondition) (bool, error) {
+	if condition == WaitNone {
+		return true, nil
+	}
+	info, err := client.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container '%s': %w", containerID, err)
+	}
+	if info.State == nil || !info.State.Running {
+		return false, nil
+	}
+	switch condition {
+	case WaitRunning:
+		return true, nil
+	case WaitHealthy:
+		if info.State.Health == nil {
+			return false, fmt.Errorf("container '%s' has no healthcheck", containerID)
+		}
+		return info.State.Health.Status == types.Healthy, nil
+	default:
+		return false, fmt.Errorf("unknown wait condition '%s'", condition)
+	}
+}
+
+// hasName determines if the container has the given name exactly, as the
+// docker API name filter also matches partial names.
+func hasName(container types.Container, name string) bool {
+	for _, n := range container.Names {
+		if strings.TrimPrefix(n, "/") == strings.TrimPrefix(name, "/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	docker "github.com/docker/docker/client"
+)
+
+// fakeContainer is a container of the fake docker engine.
+type fakeContainer struct {
+	id      string
+	name    string
+	labels  map[string]string
+	running bool
+	health  string
+}
+
+// fakeEngine is a minimal fake of the docker engine API, supporting the
+// listing and inspection of containers.
+type fakeEngine struct {
+	lock       sync.Mutex
+	containers []*fakeContainer
+	lists      int
+	inspects   int
+
+	// onList and onInspect are called (with the lock held) before each list
+	// and inspect request, allowing containers to change over time.
+	onList    func(e *fakeEngine)
+	onInspect func(e *fakeEngine)
+}
+
+func (e *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	path := r.URL.Path[strings.Index(r.URL.Path[1:], "/")+1:]
+	switch {
+	case path == "/containers/json":
+		e.lists++
+		if e.onList != nil {
+			e.onList(e)
+		}
+		args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list := make([]types.Container, 0)
+		for _, c := range e.containers {
+			if e.matches(c, args) {
+				list = append(list, types.Container{ID: c.id, Names: []string{"/" + c.name}, Labels: c.labels})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/json"):
+		e.inspects++
+		if e.onInspect != nil {
+			e.onInspect(e)
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/containers/"), "/json")
+		for _, c := range e.containers {
+			if c.id == id {
+				state := &types.ContainerState{Running: c.running}
+				if c.health != "" {
+					state.Health = &types.Health{Status: c.health}
+				}
+				json.NewEncoder(w).Encode(types.ContainerJSON{
+					ContainerJSONBase: &types.ContainerJSONBase{ID: c.id, State: state},
+				})
+				return
+			}
+		}
+		http.Error(w, `{"message": "no such container"}`, http.StatusNotFound)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// matches applies the name (substring, including the leading slash) and label
+// filters as the docker engine does.
+func (e *fakeEngine) matches(c *fakeContainer, args filters.Args) bool {
+	for _, name := range args.Get("name") {
+		if !strings.Contains("/"+c.name, name) {
+			return false
+		}
+	}
+	for _, label := range args.Get("label") {
+		k, v, _ := strings.Cut(label, "=")
+		if c.labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// newFakeClient starts the fake engine, returning a client connected to it.
+func newFakeClient(t *testing.T, engine *fakeEngine) *docker.Client {
+	server := httptest.NewServer(engine)
+	t.Cleanup(server.Close)
+	client, err := docker.NewClientWithOpts(docker.WithHost("tcp://"+server.Listener.Addr().String()), docker.WithVersion("1.41"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+// lab returns containers as created by docker compose, along with others.
+func lab() []*fakeContainer {
+	compose := func(service string) map[string]string {
+		return map[string]string{composeProjectLabel: "lab", composeServiceLabel: service, "role": service}
+	}
+	return []*fakeContainer{
+		{id: "id-router1", name: "lab-router1-1", labels: compose("router1"), running: true},
+		{id: "id-router10", name: "lab-router10-1", labels: compose("router10"), running: true},
+		{id: "id-host", name: "host", labels: map[string]string{"role": "host", "tier": "edge"}, running: true},
+		{id: "id-host2", name: "host2", labels: map[string]string{"role": "host"}, running: true},
+	}
+}
+
+func TestResolve(t *testing.T) {
+	client := newFakeClient(t, &fakeEngine{containers: lab()})
+	tests := []struct {
+		name     string
+		selector Selector
+		id       string
+	}{
+		{"exact name", Selector{Name: "host"}, "id-host"},
+		{"exact name with slash", Selector{Name: "/lab-router1-1"}, "id-router1"},
+		{"labels", Selector{Labels: map[string]string{"role": "host", "tier": "edge"}}, "id-host"},
+		{"compose service", Selector{ComposeProject: "lab", ComposeService: "router1"}, "id-router1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := Resolve(context.Background(), client, test.selector)
+			if err != nil {
+				t.Fatalf("failed to resolve: %v", err)
+			}
+			if id != test.id {
+				t.Errorf("resolved %s, expected %s", id, test.id)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	client := newFakeClient(t, &fakeEngine{containers: lab()})
+	if _, err := Resolve(context.Background(), client, Selector{Name: "missing"}); !errors.Is(err, ErrNoContainer) {
+		t.Errorf("expected no container error, got %v", err)
+	}
+	if _, err := Resolve(context.Background(), client, Selector{Labels: map[string]string{"role": "host"}}); err == nil || errors.Is(err, ErrNoContainer) {
+		t.Errorf("expected multiple containers error, got %v", err)
+	}
+}
+
+func TestResolvedExecutorDoesNotRetryAmbiguousSelector(t *testing.T) {
+	engine := &fakeEngine{containers: lab()}
+	client := newFakeClient(t, engine)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := ResolvedExecutor(ctx, client, Selector{Labels: map[string]string{"role": "host"}}, WaitNone, ""); err == nil {
+		t.Fatal("expected an error")
+	}
+	if time.Since(start) > time.Second || engine.lists != 1 {
+		t.Errorf("ambiguous selector was retried %d times", engine.lists)
+	}
+}
+
+func TestResolvedExecutorWaitsForContainer(t *testing.T) {
+	defer func(interval time.Duration) { ResolvePollInterval = interval }(ResolvePollInterval)
+	ResolvePollInterval = 10 * time.Millisecond
+	engine := &fakeEngine{
+		onList: func(e *fakeEngine) {
+			if e.lists == 3 {
+				e.containers = append(e.containers, &fakeContainer{id: "id-late", name: "late", health: types.Starting, running: true})
+			}
+		},
+		onInspect: func(e *fakeEngine) {
+			if e.inspects == 3 {
+				e.containers[0].health = types.Healthy
+			}
+		},
+	}
+	client := newFakeClient(t, engine)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := ResolvedExecutor(ctx, client, Selector{Name: "late"}, WaitHealthy, ""); err != nil {
+		t.Fatalf("failed to resolve: %v", err)
+	}
+	if engine.lists != 3 || engine.inspects != 3 {
+		t.Errorf("unexpected requests: %d lists, %d inspects", engine.lists, engine.inspects)
+	}
+}
+
+func TestWaitHealthyWithoutHealthcheck(t *testing.T) {
+	client := newFakeClient(t, &fakeEngine{containers: lab()})
+	if err := Wait(context.Background(), client, "id-host", WaitHealthy); err == nil {
+		t.Error("expected an error for a container without a healthcheck")
+	}
+	if err := Wait(context.Background(), client, "id-host", WaitRunning); err != nil {
+		t.Errorf("failed to wait for running container: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package dryrun
+
+import (
+	"fmt"
+
+	"github.com/willfantom/nescript"
+)
+
+// Executor returns an ExecFunc that never runs the given cmd/script. Instead,
+// the cmd is compiled (which has no effect if it already is) and the exact
+// argv, formatted string (using the cmd's Formatter), env vars and target that
+// would have been used are recorded by the given recorder (if not nil). The
+// returned process is synthetic, exiting immediately with a zero exit code and
+// no output. The target is only used for the record, so can be any string that
+// identifies where the cmd would have been executed. Secret values of the cmd
+// are masked in the record.
+func Executor(recorder *Recorder, target string) nescript.ExecFunc {
+	return func(c nescript.Cmd) (nescript.Process, error) {
+		compiled, err := c.Compile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile cmd for dry-run: %w", err)
+		}
+		record := Record{
+			Target:    target,
+			Argv:      redactAll(compiled, compiled.Raw()),
+			Formatted: compiled.String(),
+			Env:       redactAll(compiled, compiled.Env()),
+		}
+		if recorder != nil {
+			recorder.add(record)
+		}
+		return &DryRunProcess{
+			record: record,
+		}, nil
+	}
+}
+
+// redactAll returns a copy of the values with the secrets of the cmd masked.
+func redactAll(c nescript.Cmd, values []string) []string {
+	redacted := make([]string, len(values))
+	for idx, value := range values {
+		redacted[idx] = c.Redact(value)
+	}
+	return redacted
+}
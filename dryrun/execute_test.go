@@ -0,0 +1,56 @@
+package dryrun
+
+import (
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestExecutorRecordsCompiledScript(t *testing.T) {
+	script, err := nescript.ParseScript("# ---\n# inputs:\n#   iface: {required: true}\n# ---\nip link show {{.iface}}")
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+	recorder := NewRecorder()
+	process, err := script.WithField("iface", "eth0").Cmd().CompileExec(Executor(recorder, "node"))
+	if err != nil {
+		t.Fatalf("failed to dry-run script: %v", err)
+	}
+	if _, err := process.Result(); err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	records := recorder.Records()
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if argv := records[0].Argv; argv[len(argv)-1] != "# ---\n# inputs:\n#   iface: {required: true}\n# ---\nip link show eth0" {
+		t.Errorf("unexpected argv: %q", argv)
+	}
+}
+
+func TestExecutorRecordsCompiledCmd(t *testing.T) {
+	recorder := NewRecorder()
+	cmd := nescript.NewCmd("echo", "{{.x}}", "{{.token}}").WithField("x", "{{.y}}").WithSecretField("token", "s3cr3t")
+	if _, err := cmd.Exec(Executor(recorder, "node")); err != nil {
+		t.Fatalf("failed to dry-run cmd: %v", err)
+	}
+	if _, err := cmd.CompileExec(Executor(recorder, "node")); err != nil {
+		t.Fatalf("failed to dry-run compiled cmd: %v", err)
+	}
+	for _, record := range recorder.Records() {
+		if argv := record.Argv; len(argv) != 3 || argv[1] != "{{.y}}" || argv[2] != "****" {
+			t.Errorf("compiled cmd was not recorded: %q", argv)
+		}
+	}
+}
+
+func TestExecutorCompileError(t *testing.T) {
+	recorder := NewRecorder()
+	cmd := nescript.NewCmd("echo", "{{.missing}}").WithStrict(true)
+	if _, err := cmd.Exec(Executor(recorder, "node")); err == nil {
+		t.Error("expected the cmd to fail to compile")
+	}
+	if len(recorder.Records()) != 0 {
+		t.Error("cmd that failed to compile was recorded")
+	}
+}
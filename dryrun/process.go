@@ -0,0 +1,39 @@
+package dryrun
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/willfantom/nescript"
+)
+
+// DryRunProcess represents a cmd/script that was never executed. It can not be
+// controlled, and its result is always a zero exit code with no output.
+type DryRunProcess struct {
+	record Record
+}
+
+// Record returns the details of the cmd that would have been executed.
+func (p *DryRunProcess) Record() Record {
+	return p.record
+}
+
+func (p *DryRunProcess) Kill() error {
+	return fmt.Errorf("can not kill dry-run process")
+}
+
+func (p *DryRunProcess) Signal(s os.Signal) error {
+	return fmt.Errorf("can not signal dry-run process")
+}
+
+func (p *DryRunProcess) Write(input string) error {
+	return nil
+}
+
+func (p *DryRunProcess) Result() (*nescript.Result, error) {
+	return &nescript.Result{}, nil
+}
+
+func (p *DryRunProcess) Close() {
+	// nothing to close
+}
@@ -0,0 +1,49 @@
+package dryrun
+
+import (
+	"sync"
+)
+
+// Record describes a single cmd/script that would have been executed.
+type Record struct {
+	Target    string   `json:"target"`
+	Argv      []string `json:"argv"`
+	Formatted string   `json:"formatted"`
+	Env       []string `json:"env"`
+}
+
+// Recorder collects the records of every cmd/script passed to the dry-run
+// executors it is used with. It is safe to use a recorder with many executors
+// concurrently, for example one per target in a topology.
+type Recorder struct {
+	records []Record
+	lock    sync.Mutex
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		records: make([]Record, 0),
+	}
+}
+
+// Records returns a copy of every record collected, in the order the cmds were
+// passed to the executors.
+func (r *Recorder) Records() []Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Record{}, r.records...)
+}
+
+// Reset clears the records collected so far.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records = make([]Record, 0)
+}
+
+func (r *Recorder) add(record Record) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.records = append(r.records, record)
+}
@@ -0,0 +1,221 @@
+package escalate
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/willfantom/nescript"
+)
+
+// Tool is a privilege escalation program that a cmd can be run under.
+type Tool string
+
+const (
+	ToolSudo Tool = "sudo"
+	ToolDoas Tool = "doas"
+)
+
+// Sudo returns a middleware that runs every cmd as root using sudo. If a
+// password is given, it is supplied to sudo via the process's stdin when sudo
+// prompts for it, else sudo is run non-interactively (so must not require a
+// password).
+func Sudo(password string) nescript.Middleware {
+	return Middleware(ToolSudo, "", password)
+}
+
+// Doas returns a middleware that runs every cmd as root using doas. As doas
+// can only read a password from a terminal, the target must be configured to
+// allow the user to run commands without a password (e.g. via nopass).
+func Doas() nescript.Middleware {
+	return Middleware(ToolDoas, "", "")
+}
+
+// Middleware returns a middleware that rewrites every cmd to be run under the
+// given tool as the given user (or root if user is empty). The env vars of the
+// cmd are only given to the executor, so are never visible in the command line
+// of the process. As escalation tools will often reset the environment, sudo
+// is asked to preserve them via --preserve-env (which the target's sudo policy
+// must allow), whilst doas must be configured to keep them (e.g. via keepenv
+// or setenv in doas.conf). As the cmd is rewritten before being passed to the
+// executor, this works with any executor (local, sshe, docker, etc...).
+//
+// When a password is given (only supported by sudo), sudo is made to ignore
+// any cached credentials and read the password from stdin, using a unique
+// prompt. The password is written to the process's stdin only when the prompt
+// is seen in the process's stderr (so never if the target does not require a
+// password), and the prompt is removed from the stderr. This requires an
+// executor that supports live output (see nescript.Cmd.WithOutput), as do the
+// local, sshe and docker executors. Anything written to the process's stdin
+// before sudo has read the password is read by sudo as the password.
+func Middleware(tool Tool, user, password string) nescript.Middleware {
+	return func(next nescript.ExecFunc) nescript.ExecFunc {
+		return func(c nescript.Cmd) (nescript.Process, error) {
+			var responder *passwordResponder
+			if tool == ToolSudo && password != "" {
+				prompt, err := newPrompt()
+				if err != nil {
+					return nil, err
+				}
+				stdout, stderr := c.Output()
+				responder = &passwordResponder{
+					prompt:   prompt,
+					password: password,
+					stderr:   stderr,
+				}
+				c = c.WithOutput(stdout, responder)
+			}
+			prefix, err := tool.prefix(user, responder, envKeys(c))
+			if err != nil {
+				return nil, err
+			}
+			process, err := next(c.WithPrefix(prefix...))
+			if err != nil {
+				return nil, err
+			}
+			if responder == nil {
+				return process, nil
+			}
+			responder.setProcess(process)
+			return &escalatedProcess{
+				Process:   process,
+				responder: responder,
+			}, nil
+		}
+	}
+}
+
+// prefix returns the args needed to run a cmd under the tool, where the tool
+// is asked to preserve the env vars with the given keys. If a responder is
+// given, sudo reads the password from stdin after printing its prompt.
+func (t Tool) prefix(user string, responder *passwordResponder, preserveEnv []string) ([]string, error) {
+	var prefix []string
+	switch t {
+	case ToolSudo:
+		prefix = []string{"sudo"}
+		if responder != nil {
+			prefix = append(prefix, "-S", "-k", "--prompt="+responder.prompt)
+		} else {
+			prefix = append(prefix, "-n")
+		}
+		if len(preserveEnv) > 0 {
+			prefix = append(prefix, "--preserve-env="+strings.Join(preserveEnv, ","))
+		}
+	case ToolDoas:
+		if responder != nil {
+			return nil, fmt.Errorf("doas can not be given a password via stdin")
+		}
+		prefix = []string{"doas", "-n"}
+	default:
+		return nil, fmt.Errorf("unknown privilege escalation tool '%s'", t)
+	}
+	if user != "" {
+		prefix = append(prefix, "-u", user)
+	}
+	return append(prefix, "--"), nil
+}
+
+// envKeys returns the unique keys of the env vars of the cmd, in order.
+func envKeys(c nescript.Cmd) []string {
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, e := range c.Env() {
+		key, _, _ := strings.Cut(e, "=")
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// newPrompt returns a random password prompt for sudo, which is very unlikely
+// to appear in the output of a cmd.
+func newPrompt() (string, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate sudo prompt: %w", err)
+	}
+	return "[nescript-sudo-" + hex.EncodeToString(id) + "]", nil
+}
+
+// passwordResponder is the stderr of a sudo process, writing the password to
+// the process's stdin each time the prompt is written. Anything else written
+// is passed on to the original stderr writer (if any).
+type passwordResponder struct {
+	prompt   string
+	password string
+	stderr   io.Writer
+
+	lock    sync.Mutex
+	process nescript.Process
+	pending int
+	held    string
+}
+
+func (pr *passwordResponder) Write(p []byte) (int, error) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	data := pr.held + string(p)
+	prompts := strings.Count(data, pr.prompt)
+	data = strings.ReplaceAll(data, pr.prompt, "")
+	pr.held = ""
+	for n := len(pr.prompt) - 1; n > 0; n-- {
+		if strings.HasSuffix(data, pr.prompt[:n]) {
+			pr.held = data[len(data)-n:]
+			data = data[:len(data)-n]
+			break
+		}
+	}
+	if pr.stderr != nil && data != "" {
+		pr.stderr.Write([]byte(data))
+	}
+	for ; prompts > 0; prompts-- {
+		if pr.process == nil {
+			pr.pending++
+		} else {
+			pr.process.Write(pr.password + "\n")
+		}
+	}
+	return len(p), nil
+}
+
+// setProcess sets the process that the password is written to, answering any
+// prompts that were written before the process was known.
+func (pr *passwordResponder) setProcess(process nescript.Process) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	pr.process = process
+	for ; pr.pending > 0; pr.pending-- {
+		process.Write(pr.password + "\n")
+	}
+}
+
+// flush passes on anything held back as the possible start of a prompt.
+func (pr *passwordResponder) flush() {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	if pr.stderr != nil && pr.held != "" {
+		pr.stderr.Write([]byte(pr.held))
+	}
+	pr.held = ""
+}
+
+// escalatedProcess is a sudo process that is given a password, where the
+// prompts are removed from the stderr of the result.
+type escalatedProcess struct {
+	nescript.Process
+	responder *passwordResponder
+}
+
+func (p *escalatedProcess) Result() (*nescript.Result, error) {
+	result, err := p.Process.Result()
+	p.responder.flush()
+	if result != nil {
+		result.StdErr = strings.ReplaceAll(result.StdErr, p.responder.prompt, "")
+	}
+	return result, err
+}
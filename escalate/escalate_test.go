@@ -0,0 +1,116 @@
+package escalate
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/willfantom/nescript"
+	"github.com/willfantom/nescript/dryrun"
+	"github.com/willfantom/nescript/local"
+)
+
+// fakeSudo is a stand-in for sudo that prompts for the password (if asked to
+// read it from stdin) only when requirePassword is true, before executing the
+// given command.
+const fakeSudo = `#!/bin/sh
+prompt=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--prompt=*) prompt="${1#--prompt=}"; shift ;;
+	-u) shift 2 ;;
+	--) shift; break ;;
+	-*) shift ;;
+	*) break ;;
+	esac
+done
+if [ "$REQUIRE_PASSWORD" = true ] && [ -n "$prompt" ]; then
+	printf '%s' "$prompt" >&2
+	read -r password
+	[ "$password" = hunter2 ] || { echo "incorrect password" >&2; exit 1; }
+fi
+exec "$@"
+`
+
+// installFakeSudo places the fake sudo first on the PATH.
+func installFakeSudo(t *testing.T, requirePassword bool) {
+	dir := t.TempDir()
+	script := strings.Replace(fakeSudo, `"$REQUIRE_PASSWORD"`, map[bool]string{true: "true", false: "false"}[requirePassword], 1)
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake sudo: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSudoAnswersPrompt(t *testing.T) {
+	installFakeSudo(t, true)
+	live := &bytes.Buffer{}
+	cmd := nescript.NewCmd("sh", "-c", "echo ok; echo warning >&2").WithOutput(nil, live)
+	process, err := cmd.Exec(local.Executor("").With(Sudo("hunter2")))
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if result.ExitCode != 0 || result.StdOut != "ok\n" {
+		t.Fatalf("unexpected result: %d %q %q", result.ExitCode, result.StdOut, result.StdErr)
+	}
+	if result.StdErr != "warning\n" || live.String() != "warning\n" {
+		t.Errorf("prompt was not removed from stderr: %q (live %q)", result.StdErr, live.String())
+	}
+}
+
+func TestSudoWithoutPromptDoesNotWritePassword(t *testing.T) {
+	installFakeSudo(t, false)
+	cmd := nescript.NewCmd("timeout", "1", "cat")
+	process, err := cmd.Exec(local.Executor("").With(Sudo("hunter2")))
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if strings.Contains(result.StdOut, "hunter2") {
+		t.Errorf("password was given to the escalated cmd: %q", result.StdOut)
+	}
+}
+
+func TestEnvIsNotOnArgv(t *testing.T) {
+	recorder := dryrun.NewRecorder()
+	cmd := nescript.NewCmd("env").WithEnv("PUBLIC=visible", "PUBLIC=again").WithSecretEnv("TOKEN=s3cr3t-value").WithLocalOSEnv()
+	if _, err := cmd.Exec(dryrun.Executor(recorder, "node").With(Sudo(""))); err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	record := recorder.Records()[0]
+	argv := strings.Join(record.Argv, " ")
+	if strings.Contains(argv, "=visible") || strings.Contains(argv, "TOKEN=") || strings.Contains(argv, "PATH=") {
+		t.Errorf("env vars are on argv: %s", argv)
+	}
+	if !strings.HasPrefix(argv, "sudo -n --preserve-env=PUBLIC,TOKEN,") || !strings.Contains(argv, ",PATH") {
+		t.Errorf("env vars are not preserved by sudo: %s", argv)
+	}
+	if len(record.Env) != len(cmd.Env()) {
+		t.Errorf("env vars were not given to the executor: %q", record.Env)
+	}
+}
+
+func TestSudoPreservesEnv(t *testing.T) {
+	installFakeSudo(t, false)
+	cmd := nescript.NewCmd("sh", "-c", "echo $PUBLIC").WithEnv("PUBLIC=visible")
+	process, err := cmd.Exec(local.Executor("").With(Sudo("")))
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if result.StdOut != "visible\n" {
+		t.Errorf("env var was not given to the cmd: %q", result.StdOut)
+	}
+}
@@ -62,7 +62,31 @@ func (c Cmd) OSCmd() (*exec.Cmd, error) {
 }
 
 // String uses the command's formatter to convert the raw command (a main
-// executable path and a slice of arguments) into a single string.
+// executable path and a slice of arguments) into a single string. Any secret
+// values are masked, thus this is suitable for logging, but not for execution
+// (see Formatted).
 func (c Cmd) String() string {
-	return c.formatter(c.Raw())
+	return c.Redact(c.Formatted())
+}
+
+// Formatted uses the command's formatter to convert the raw command into a
+// single string, as String does, but without masking secret values.
+func (c Cmd) Formatted() string {
+	if c.formatter != nil {
+		return c.formatter(c.Raw())
+	}
+	return c.defaultFormatter(c.Raw())
+}
+
+// ShellFormatted converts the raw command into a single string to be executed
+// by a POSIX shell, without masking secret values. The command's formatter is
+// used if one has been set (see WithFormatter), otherwise ShellQuoteFormatter
+// is used, such that the shell receives exactly the command's argv. This
+// should be used by executors that run the command via a single string, such
+// as a remote shell.
+func (c Cmd) ShellFormatted() string {
+	if c.formatter != nil {
+		return c.formatter(c.Raw())
+	}
+	return ShellQuoteFormatter()(c.Raw())
 }
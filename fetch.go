@@ -0,0 +1,176 @@
+package nescript
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScriptFetcher retrieves scripts from remote HTTP(S) resources. Optionally,
+// fetched scripts can be cached on disk, in which case conditional requests
+// (using the ETag and Last-Modified headers of the cached response) are used
+// to avoid downloading unchanged scripts, and the cached script is used if the
+// remote resource can not be reached or responds with a server error.
+type ScriptFetcher struct {
+	// Client is used to make requests. If nil, a client with a 30s timeout is
+	// used.
+	Client *http.Client
+
+	// CacheDir is the directory that fetched scripts are cached in. If empty,
+	// scripts are not cached.
+	CacheDir string
+}
+
+// fetchCacheEntry is the information stored alongside a cached script.
+type fetchCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+var (
+	defaultFetchClient *http.Client = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+)
+
+// Fetch creates a Script from the body of the resource at the given URL. Any
+// non-2xx response is considered an error. If expectedSHA256 is not empty, the
+// SHA-256 digest of the script (hex encoded) must match it, else this errors.
+// When a cache directory is set, a cached copy of the script is used if the
+// resource is unchanged (a 304 response), the request fails, or the response
+// is a server error (5xx), provided the cached copy also matches the expected
+// digest.
+func (sf ScriptFetcher) Fetch(ctx context.Context, link, expectedSHA256 string) (*Script, error) {
+	scriptURL, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse given link as a url: %w", err)
+	}
+	client := sf.Client
+	if client == nil {
+		client = defaultFetchClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, scriptURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request for script: %w", err)
+	}
+	cached, entry := sf.cached(scriptURL.String())
+	if cached != nil {
+		if entry.ETag != "" {
+			request.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			request.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		if cached != nil && ctx.Err() == nil {
+			return sf.verified(cached, expectedSHA256)
+		}
+		return nil, fmt.Errorf("could not get script from url: %w", err)
+	}
+	defer response.Body.Close()
+	if cached != nil && (response.StatusCode == http.StatusNotModified || response.StatusCode >= 500) {
+		return sf.verified(cached, expectedSHA256)
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("could not get script from url: unexpected status '%s'", response.Status)
+	}
+	bodyBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the downloaded script: %w", err)
+	}
+	script, err := sf.verified(bodyBytes, expectedSHA256)
+	if err != nil {
+		return nil, err
+	}
+	if err := sf.cache(scriptURL.String(), bodyBytes, fetchCacheEntry{
+		URL:          scriptURL.String(),
+		ETag:         response.Header.Get("ETag"),
+		LastModified: response.Header.Get("Last-Modified"),
+	}); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// verified creates a script from the given bytes, provided they match the
+// expected SHA-256 digest (if given).
+func (sf ScriptFetcher) verified(scriptBytes []byte, expectedSHA256 string) (*Script, error) {
+	if expectedSHA256 != "" {
+		digest := sha256.Sum256(scriptBytes)
+		if actual := hex.EncodeToString(digest[:]); !strings.EqualFold(actual, expectedSHA256) {
+			return nil, fmt.Errorf("script digest '%s' does not match expected digest '%s'", actual, expectedSHA256)
+		}
+	}
+	return ParseScript(string(scriptBytes))
+}
+
+// cachePaths returns the paths of the cached script and its cache entry for
+// the given URL.
+func (sf ScriptFetcher) cachePaths(link string) (string, string) {
+	key := sha256.Sum256([]byte(link))
+	base := filepath.Join(sf.CacheDir, hex.EncodeToString(key[:]))
+	return base + ".script", base + ".json"
+}
+
+// cached returns the cached script and its cache entry for the given URL, or
+// nil if caching is disabled or the script is not cached.
+func (sf ScriptFetcher) cached(link string) ([]byte, fetchCacheEntry) {
+	entry := fetchCacheEntry{}
+	if sf.CacheDir == "" {
+		return nil, entry
+	}
+	scriptPath, entryPath := sf.cachePaths(link)
+	entryBytes, err := os.ReadFile(entryPath)
+	if err != nil || json.Unmarshal(entryBytes, &entry) != nil || entry.URL != link {
+		return nil, entry
+	}
+	scriptBytes, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return nil, entry
+	}
+	return scriptBytes, entry
+}
+
+// cache stores the script and its cache entry, if caching is enabled.
+func (sf ScriptFetcher) cache(link string, scriptBytes []byte, entry fetchCacheEntry) error {
+	if sf.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(sf.CacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create script cache: %w", err)
+	}
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to cache script: %w", err)
+	}
+	scriptPath, entryPath := sf.cachePaths(link)
+	if err := writeFileAtomic(scriptPath, scriptBytes); err != nil {
+		return fmt.Errorf("failed to cache script: %w", err)
+	}
+	if err := writeFileAtomic(entryPath, entryBytes); err != nil {
+		return fmt.Errorf("failed to cache script: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the content to a temporary file, then renames it to
+// the given path, so that a partially written file is never read.
+func writeFileAtomic(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
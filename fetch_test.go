@@ -0,0 +1,203 @@
+package nescript
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fetchScript     string = "echo hello"
+	fetchETag       string = `"v1"`
+	fetchModifiedAt string = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+// scriptServer serves a script, recording the conditional headers of each
+// request. Responses use the given status, or the script if zero.
+type scriptServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	requests []http.Header
+}
+
+func newScriptServer(t *testing.T) *scriptServer {
+	t.Helper()
+	server := &scriptServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.lock.Lock()
+		defer server.lock.Unlock()
+		server.requests = append(server.requests, r.Header.Clone())
+		if server.status != 0 {
+			w.WriteHeader(server.status)
+			return
+		}
+		w.Header().Set("ETag", fetchETag)
+		w.Header().Set("Last-Modified", fetchModifiedAt)
+		if r.Header.Get("If-None-Match") == fetchETag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(fetchScript))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (ss *scriptServer) respondWith(status int) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.status = status
+}
+
+func (ss *scriptServer) lastRequest() http.Header {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return ss.requests[len(ss.requests)-1]
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFetchDigest(t *testing.T) {
+	server := newScriptServer(t)
+	fetcher := ScriptFetcher{}
+	tests := []struct {
+		name   string
+		digest string
+		err    bool
+	}{
+		{"no digest", "", false},
+		{"matching digest", sha256Hex(fetchScript), false},
+		{"matching uppercase digest", strings.ToUpper(sha256Hex(fetchScript)), false},
+		{"mismatched digest", sha256Hex("echo pwned"), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := fetcher.Fetch(context.Background(), server.URL, test.digest)
+			if test.err {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to fetch: %v", err)
+			}
+			if script.Raw() != fetchScript {
+				t.Errorf("unexpected script: %q", script.Raw())
+			}
+		})
+	}
+}
+
+func TestFetchRejectsErrorStatus(t *testing.T) {
+	server := newScriptServer(t)
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError, http.StatusNotModified} {
+		server.respondWith(status)
+		if _, err := (ScriptFetcher{}).Fetch(context.Background(), server.URL, ""); err == nil {
+			t.Errorf("expected an error for status %d", status)
+		}
+	}
+}
+
+func TestFetchRevalidatesCache(t *testing.T) {
+	server := newScriptServer(t)
+	fetcher := ScriptFetcher{CacheDir: t.TempDir()}
+	if _, err := fetcher.Fetch(context.Background(), server.URL, ""); err != nil {
+		t.Fatalf("failed to fetch: %v", err)
+	}
+	if header := server.lastRequest(); header.Get("If-None-Match") != "" || header.Get("If-Modified-Since") != "" {
+		t.Errorf("expected an unconditional first request, got %v", header)
+	}
+	script, err := fetcher.Fetch(context.Background(), server.URL, sha256Hex(fetchScript))
+	if err != nil {
+		t.Fatalf("failed to fetch cached script: %v", err)
+	}
+	if script.Raw() != fetchScript {
+		t.Errorf("unexpected cached script: %q", script.Raw())
+	}
+	header := server.lastRequest()
+	if header.Get("If-None-Match") != fetchETag {
+		t.Errorf("expected If-None-Match %s, got %q", fetchETag, header.Get("If-None-Match"))
+	}
+	if header.Get("If-Modified-Since") != fetchModifiedAt {
+		t.Errorf("expected If-Modified-Since %s, got %q", fetchModifiedAt, header.Get("If-Modified-Since"))
+	}
+	if _, err := fetcher.Fetch(context.Background(), server.URL, sha256Hex("echo other")); err == nil {
+		t.Error("expected the cached script to be checked against the digest")
+	}
+}
+
+func TestFetchFallsBackToCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		closed   bool
+		fallback bool
+	}{
+		{"server error", http.StatusInternalServerError, false, true},
+		{"bad gateway", http.StatusBadGateway, false, true},
+		{"unreachable", 0, true, true},
+		{"not found", http.StatusNotFound, false, false},
+		{"forbidden", http.StatusForbidden, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newScriptServer(t)
+			fetcher := ScriptFetcher{CacheDir: t.TempDir()}
+			if _, err := fetcher.Fetch(context.Background(), server.URL, ""); err != nil {
+				t.Fatalf("failed to fetch: %v", err)
+			}
+			server.respondWith(test.status)
+			if test.closed {
+				server.Close()
+			}
+			script, err := fetcher.Fetch(context.Background(), server.URL, "")
+			if !test.fallback {
+				if err == nil {
+					t.Error("expected an error rather than the cached script")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the cached script, got: %v", err)
+			}
+			if script.Raw() != fetchScript {
+				t.Errorf("unexpected cached script: %q", script.Raw())
+			}
+		})
+	}
+}
+
+func TestFetchDoesNotCacheMismatchedDigest(t *testing.T) {
+	server := newScriptServer(t)
+	fetcher := ScriptFetcher{CacheDir: t.TempDir()}
+	if _, err := fetcher.Fetch(context.Background(), server.URL, sha256Hex("echo other")); err == nil {
+		t.Fatal("expected an error for a mismatched digest")
+	}
+	server.respondWith(http.StatusInternalServerError)
+	if _, err := fetcher.Fetch(context.Background(), server.URL, ""); err == nil {
+		t.Error("expected no cached script to fall back to")
+	}
+}
+
+func TestFetchCancelledContextDoesNotUseCache(t *testing.T) {
+	server := newScriptServer(t)
+	fetcher := ScriptFetcher{CacheDir: t.TempDir()}
+	if _, err := fetcher.Fetch(context.Background(), server.URL, ""); err != nil {
+		t.Fatalf("failed to fetch: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fetcher.Fetch(ctx, server.URL, ""); err == nil {
+		t.Error("expected an error for a cancelled context")
+	}
+}
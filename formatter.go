@@ -2,6 +2,7 @@ package nescript
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -13,6 +14,10 @@ type Formatter func([]string) string
 var (
 	defaultScriptFormatter Formatter = QuoteLastArgFormatter("\"", "\"")
 	defaultCmdFormatter    Formatter = SpaceSepFormatter()
+
+	// shellSafeRegex matches arguments that a POSIX shell treats as a single
+	// literal word without quoting.
+	shellSafeRegex *regexp.Regexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
 )
 
 // SpaceSepFormatter joins all command and all arguments with a single space to
@@ -30,30 +35,72 @@ func SpaceSepFormatter() Formatter {
 }
 
 // QuoteIfSpaceFormatter acts similarly to SpaceSepFormatter, however if any
-// argument contains a space, it will be wrapped in quotes.
+// argument contains a space, it will be wrapped in quotes. Quotes (or other
+// special characters) within the arguments are not escaped, thus the result is
+// not suitable for passing to a shell (see ShellQuoteFormatter).
 func QuoteIfSpaceFormatter(openQuote, closeQuote string) Formatter {
 	return func(raw []string) string {
 		if len(raw) <= 0 {
 			return ""
 		}
+		formatted := make([]string, len(raw))
 		for idx, arg := range raw {
+			formatted[idx] = arg
 			if strings.Contains(arg, " ") {
-				raw[idx] = fmt.Sprintf("%s%s%s", openQuote, raw[idx], closeQuote)
+				formatted[idx] = fmt.Sprintf("%s%s%s", openQuote, arg, closeQuote)
 			}
 		}
-		return strings.Join(raw, " ")
+		return strings.Join(formatted, " ")
 	}
 }
 
 // QuoteLastArgFormatter joins all command and all arguments with a single space
 // to create a string. However, it will wrap the final argument in quotes (using
-// a the given quote chars).
+// a the given quote chars). As with QuoteIfSpaceFormatter, the final argument
+// is not escaped.
 func QuoteLastArgFormatter(openQuote, closeQuote string) Formatter {
 	return func(raw []string) string {
 		if len(raw) <= 0 {
 			return ""
 		}
-		raw[len(raw)-1] = fmt.Sprintf("%s%s%s", openQuote, raw[len(raw)-1], closeQuote)
-		return strings.Join(raw, " ")
+		formatted := append([]string{}, raw...)
+		formatted[len(formatted)-1] = fmt.Sprintf("%s%s%s", openQuote, raw[len(raw)-1], closeQuote)
+		return strings.Join(formatted, " ")
+	}
+}
+
+// ShellQuoteFormatter joins all command and all arguments with a single space,
+// quoting any that a POSIX shell would not otherwise treat as a single literal
+// word. Arguments are wrapped in single quotes (with any single quotes within
+// them escaped), thus no expansion of $, backticks, globs, etc... occurs, and
+// newlines are preserved. Empty arguments are given as a pair of single quotes.
+// When the result is interpreted by a POSIX shell (such as a remote shell via
+// SSH), the argv is exactly the raw command. This is used by executors that
+// run cmds via a shell, unless the cmd has a formatter set (see
+// Cmd.ShellFormatted).
+func ShellQuoteFormatter() Formatter {
+	return func(raw []string) string {
+		formatted := make([]string, len(raw))
+		for idx, arg := range raw {
+			formatted[idx] = arg
+			if !shellSafeRegex.MatchString(arg) || (idx == 0 && needsCommandQuoting(arg)) {
+				formatted[idx] = ShellQuote(arg)
+			}
+		}
+		return strings.Join(formatted, " ")
+	}
+}
+
+// needsCommandQuoting determines if the word must be quoted to be treated as a
+// command name when in the command position, as it would otherwise be treated
+// as a variable assignment or a reserved word.
+func needsCommandQuoting(word string) bool {
+	if strings.Contains(word, "=") {
+		return true
+	}
+	switch word {
+	case "case", "do", "done", "elif", "else", "esac", "fi", "for", "if", "in", "then", "until", "while":
+		return true
 	}
+	return false
 }
@@ -0,0 +1,95 @@
+package nescript
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShellQuoteFormatterRoundTrip(t *testing.T) {
+	args := []string{
+		"",
+		"plain",
+		"two words",
+		"line\nbreak",
+		"trailing newline\n",
+		"tab\there",
+		"it's",
+		"''",
+		`"double"`,
+		"!",
+		"!!",
+		"~",
+		"~root/x",
+		"*",
+		"?.go",
+		"[ab]",
+		"{a,b}",
+		"$HOME",
+		"$(echo expanded)",
+		"`echo expanded`",
+		`back\slash`,
+		"#comment",
+		"a=b",
+		"-n",
+		";&|<>()",
+		"héllo wörld ✓ 日本",
+	}
+	for _, shell := range []string{"sh", "bash"} {
+		t.Run(shell, func(t *testing.T) {
+			script := ShellQuoteFormatter()(append([]string{"printf", `%s\0`}, args...))
+			output := runShell(t, shell, script)
+			got := strings.Split(output, "\x00")
+			got = got[:len(got)-1]
+			if len(got) != len(args) {
+				t.Fatalf("got %d args, expected %d, from:\n%s", len(got), len(args), script)
+			}
+			for idx, arg := range args {
+				if got[idx] != arg {
+					t.Errorf("arg %q was received as %q, from:\n%s", arg, got[idx], script)
+				}
+			}
+		})
+	}
+}
+
+func TestShellQuoteFormatterCommandPosition(t *testing.T) {
+	formatted := ShellQuoteFormatter()([]string{"if", "a=b"})
+	if formatted != "'if' a=b" {
+		t.Errorf("unexpected formatting: %s", formatted)
+	}
+}
+
+func TestDefaultFormatters(t *testing.T) {
+	cmd := NewCmd("echo", "two words", "it's")
+	if formatted := cmd.Formatted(); formatted != "echo two words it's" {
+		t.Errorf("unexpected cmd formatting: %s", formatted)
+	}
+	if formatted := cmd.ShellFormatted(); formatted != `echo 'two words' 'it'\''s'` {
+		t.Errorf("unexpected cmd shell formatting: %s", formatted)
+	}
+	script := NewScript("echo hi").Cmd()
+	if formatted := script.Formatted(); formatted != `sh -c "echo hi"` {
+		t.Errorf("unexpected script formatting: %s", formatted)
+	}
+	if formatted := script.ShellFormatted(); formatted != `sh -c 'echo hi'` {
+		t.Errorf("unexpected script shell formatting: %s", formatted)
+	}
+	custom := cmd.WithFormatter(QuoteIfSpaceFormatter("[", "]"))
+	if custom.Formatted() != "echo [two words] it's" || custom.ShellFormatted() != custom.Formatted() {
+		t.Errorf("custom formatter was not used: %s, %s", custom.Formatted(), custom.ShellFormatted())
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"":      "''",
+		"a":     "'a'",
+		"it's":  `'it'\''s'`,
+		"$HOME": "'$HOME'",
+	}
+	for value, expected := range tests {
+		if quoted := ShellQuote(value); quoted != expected {
+			t.Errorf("expected %q to be quoted as %s, got %s", value, expected, quoted)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package nescript
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// NewScriptFromFS creates a Script from the file with the given name in the
+// file system (such as an embed.FS). Any front-matter in the script is parsed
+// (see ParseFrontMatter). Also, if a metadata file exists alongside the script
+// (named as the script with ".meta.json" appended, e.g. "setup.sh.meta.json"),
+// it is parsed and applied to the script, taking precedence over the
+// front-matter (see ScriptMetadata). This can error if the file or its
+// metadata can not be read, or either contain invalid metadata.
+func NewScriptFromFS(fsys fs.FS, name string) (*Script, error) {
+	fileBytes, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get script from file system: %w", err)
+	}
+	script, err := ParseScript(string(fileBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script '%s': %w", name, err)
+	}
+	metadataBytes, err := fs.ReadFile(fsys, name+metadataSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return script, nil
+		}
+		return nil, fmt.Errorf("failed to get script metadata from file system: %w", err)
+	}
+	metadata, err := parseMetadata(metadataBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata for script '%s': %w", name, err)
+	}
+	if frontMatter := script.Metadata(); frontMatter != nil {
+		merged := frontMatter.merge(*metadata)
+		metadata = &merged
+	}
+	scriptWithMetadata := newScript(script.Raw()).withMetadata(metadata)
+	return &scriptWithMetadata, nil
+}
+
+// NewScriptsFromFS creates a Script from every file in the file system that
+// matches the glob pattern (see fs.Glob), returned as a map keyed by the path
+// of each file. Metadata files and directories are not treated as scripts.
+// This can error if the pattern is malformed, or any script can not be loaded.
+func NewScriptsFromFS(fsys fs.FS, pattern string) (map[string]*Script, error) {
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find scripts in file system: %w", err)
+	}
+	scripts := make(map[string]*Script)
+	for _, match := range matches {
+		if strings.HasSuffix(match, metadataSuffix) {
+			continue
+		}
+		if info, err := fs.Stat(fsys, match); err != nil {
+			return nil, fmt.Errorf("failed to get script from file system: %w", err)
+		} else if info.IsDir() {
+			continue
+		}
+		script, err := NewScriptFromFS(fsys, match)
+		if err != nil {
+			return nil, err
+		}
+		scripts[match] = script
+	}
+	return scripts, nil
+}
@@ -0,0 +1,123 @@
+package nescript
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewScriptsFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"scripts/setup.sh":           {Data: []byte("echo setup {{.name}}")},
+		"scripts/setup.sh.meta.json": {Data: []byte(`{"fields": {"name": "meta"}}`)},
+		"scripts/teardown.sh":        {Data: []byte("echo teardown")},
+		"scripts/notes.txt":          {Data: []byte("not a script")},
+		"scripts/nested/inner.sh":    {Data: []byte("echo inner")},
+		"scripts/dir.sh/file":        {Data: []byte("echo file")},
+	}
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"scripts/*.sh", []string{"scripts/setup.sh", "scripts/teardown.sh"}},
+		{"scripts/*", []string{"scripts/notes.txt", "scripts/setup.sh", "scripts/teardown.sh"}},
+		{"scripts/*/*.sh", []string{"scripts/nested/inner.sh"}},
+		{"scripts/setup.*", []string{"scripts/setup.sh"}},
+		{"other/*.sh", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			scripts, err := NewScriptsFromFS(fsys, test.pattern)
+			if err != nil {
+				t.Fatalf("failed to load scripts: %v", err)
+			}
+			names := make([]string, 0, len(scripts))
+			for name := range scripts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("expected scripts %v, got %v", test.expected, names)
+			}
+		})
+	}
+}
+
+func TestNewScriptsFromFSErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		pattern string
+	}{
+		{"malformed pattern", fstest.MapFS{"a.sh": {Data: []byte("echo")}}, "[a"},
+		{"invalid metadata file", fstest.MapFS{
+			"a.sh":           {Data: []byte("echo")},
+			"a.sh.meta.json": {Data: []byte(`{"timeout": "soon"}`)},
+		}, "*.sh"},
+		{"invalid front-matter", fstest.MapFS{"a.sh": {Data: []byte("# ---\n# timeout: 5s\n")}}, "*.sh"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewScriptsFromFS(test.fsys, test.pattern); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewScriptFromFSMetadata(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ping.sh": {Data: []byte(strings.Join([]string{
+			"#!/bin/sh",
+			"# ---",
+			"# subcommand: [sh, -c]",
+			"# env: [FROM_FRONT_MATTER=1]",
+			"# inputs:",
+			"#   host: {type: string, default: localhost}",
+			"#   count: {type: int, default: 1}",
+			"# timeout: 5s",
+			"# ---",
+			"ping -c {{.count}} {{.host}}",
+		}, "\n"))},
+		"ping.sh.meta.json": {Data: []byte(`{
+			"subcommand": ["bash", "-c"],
+			"env": ["FROM_META=1"],
+			"inputs": {"count": {"type": "int", "default": 3}},
+			"timeout": "10s"
+		}`)},
+	}
+	script, err := NewScriptFromFS(fsys, "ping.sh")
+	if err != nil {
+		t.Fatalf("failed to load script: %v", err)
+	}
+	metadata := script.Metadata()
+	if metadata == nil {
+		t.Fatal("expected the script to have metadata")
+	}
+	if metadata.Timeout != 10*time.Second {
+		t.Errorf("expected the metadata file timeout, got %s", metadata.Timeout)
+	}
+	if _, ok := metadata.Inputs["host"]; !ok {
+		t.Error("expected front-matter inputs to be kept")
+	}
+	compiled, err := script.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if !strings.HasSuffix(compiled.Raw(), "ping -c 3 localhost") {
+		t.Errorf("expected the defaults of both to apply, got %q", compiled.Raw())
+	}
+	cmd := compiled.Cmd()
+	if raw := cmd.Raw(); raw[0] != "bash" || raw[1] != "-c" {
+		t.Errorf("expected the metadata file subcommand, got %q", raw)
+	}
+	if env := cmd.Env(); !reflect.DeepEqual(env, []string{"FROM_FRONT_MATTER=1", "FROM_META=1"}) {
+		t.Errorf("expected env from both, got %q", env)
+	}
+	if _, err := script.WithField("count", "three").Compile(); err == nil {
+		t.Error("expected the declared input type to be checked")
+	}
+}
@@ -0,0 +1,171 @@
+package nescript
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// DefaultFuncs returns the functions available to every script/cmd template,
+// in addition to the go template builtins. These are:
+//   - shquote: single quotes a value for use as a POSIX shell word (this does
+//     nothing with ShellEscaping, as values are already quoted)
+//   - join: joins a list with a separator, e.g. {{.List | join ","}}
+//   - split: splits a string by a separator, e.g. {{split "," .Str}}
+//   - default: a fallback for empty values, e.g. {{.Port | default 22}}
+//   - toJSON/fromJSON: encodes a value as JSON, or decodes JSON into a value
+//   - env: the value of an env var on the local system
+//   - cidrHost: the nth address in a CIDR, e.g. {{cidrHost "10.0.0.0/24" 1}}
+//   - cidrNetmask: the dotted netmask of a CIDR, e.g. 255.255.255.0
+//   - cidrPrefixLen: the prefix length of a CIDR, e.g. 24
+//   - ipAdd: an IP address offset by n, e.g. {{ipAdd "10.0.0.1" 2}}
+//   - seq: integers like the seq command (seq last, seq first last, or seq
+//     first incr last), e.g. {{range seq 3}}{{.}}{{end}} for 123
+//   - until: integers from 0 up to (but not including) n
+func DefaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"shquote":       func(v any) string { return ShellQuote(fmt.Sprint(v)) },
+		"join":          join,
+		"split":         func(sep, s string) []string { return strings.Split(s, sep) },
+		"default":       defaultValue,
+		"toJSON":        toJSON,
+		"fromJSON":      fromJSON,
+		"env":           os.Getenv,
+		"cidrHost":      cidrHost,
+		"cidrNetmask":   cidrNetmask,
+		"cidrPrefixLen": cidrPrefixLen,
+		"ipAdd":         ipAdd,
+		"seq":           seq,
+		"until":         func(n int) []int { return seq(0, 1, n-1) },
+	}
+}
+
+// join joins the elements of any slice or array as strings with a separator.
+func join(sep string, list any) (string, error) {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return "", fmt.Errorf("join requires a list, not %T", list)
+	}
+	parts := make([]string, value.Len())
+	for idx := range parts {
+		parts[idx] = fmt.Sprint(value.Index(idx).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// defaultValue returns value, unless it is nil or the zero value of its type,
+// in which case fallback is returned.
+func defaultValue(fallback, value any) any {
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return fallback
+	}
+	return value
+}
+
+func toJSON(value any) (string, error) {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode value as json: %w", err)
+	}
+	return string(jsonBytes), nil
+}
+
+func fromJSON(s string) (any, error) {
+	var value any
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		return nil, fmt.Errorf("failed to decode json: %w", err)
+	}
+	return value, nil
+}
+
+// cidrHost returns the address at the given index of the CIDR's network.
+func cidrHost(cidr string, index int) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := network.Mask.Size()
+	if index < 0 || (bits-ones < 63 && int64(index) >= int64(1)<<(bits-ones)) {
+		return "", fmt.Errorf("host %d is not within %s", index, cidr)
+	}
+	return offsetIP(network.IP, int64(index))
+}
+
+func cidrNetmask(cidr string) (string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(network.Mask).String(), nil
+}
+
+func cidrPrefixLen(cidr string) (int, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	ones, _ := network.Mask.Size()
+	return ones, nil
+}
+
+// ipAdd returns the IP address offset by n (which can be negative).
+func ipAdd(ip string, n int) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid ip address '%s'", ip)
+	}
+	return offsetIP(parsed, int64(n))
+}
+
+// offsetIP adds the offset to the given IP address, erroring if the result
+// would overflow the address space.
+func offsetIP(ip net.IP, offset int64) (string, error) {
+	if v4 := ip.To4(); v4 != nil {
+		result := int64(binary.BigEndian.Uint32(v4)) + offset
+		if result < 0 || result > 0xFFFFFFFF {
+			return "", fmt.Errorf("ip address %s offset by %d is out of range", ip, offset)
+		}
+		resultIP := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(resultIP, uint32(result))
+		return resultIP.String(), nil
+	}
+	result := new(big.Int).Add(new(big.Int).SetBytes(ip.To16()), big.NewInt(offset))
+	if result.Sign() < 0 || result.BitLen() > 8*net.IPv6len {
+		return "", fmt.Errorf("ip address %s offset by %d is out of range", ip, offset)
+	}
+	resultIP := make(net.IP, net.IPv6len)
+	result.FillBytes(resultIP)
+	return resultIP.String(), nil
+}
+
+// seq returns a list of integers in the same manner as the seq command.
+func seq(args ...int) []int {
+	first, incr, last := 1, 1, 0
+	switch len(args) {
+	case 1:
+		last = args[0]
+	case 2:
+		first, last = args[0], args[1]
+	case 3:
+		first, incr, last = args[0], args[1], args[2]
+	default:
+		return []int{}
+	}
+	values := make([]int, 0)
+	if incr > 0 {
+		for v := first; v <= last; v += incr {
+			values = append(values, v)
+		}
+	} else if incr < 0 {
+		for v := first; v >= last; v += incr {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,84 @@
+package nescript
+
+import (
+	"testing"
+)
+
+func TestDefaultFuncs(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		x        any
+		expected string
+		err      bool
+	}{
+		{"cidrHost", `{{cidrHost "10.0.0.0/24" 1}}`, nil, "10.0.0.1", false},
+		{"cidrHost network", `{{cidrHost "10.0.0.0/24" 0}}`, nil, "10.0.0.0", false},
+		{"cidrHost last", `{{cidrHost "10.0.0.0/24" 255}}`, nil, "10.0.0.255", false},
+		{"cidrHost beyond network", `{{cidrHost "10.0.0.0/24" 256}}`, nil, "", true},
+		{"cidrHost negative", `{{cidrHost "10.0.0.0/24" -1}}`, nil, "", true},
+		{"cidrHost host bits", `{{cidrHost "10.0.0.7/30" 2}}`, nil, "10.0.0.6", false},
+		{"cidrHost ipv6", `{{cidrHost "fd00::/64" 10}}`, nil, "fd00::a", false},
+		{"cidrHost ipv6 beyond network", `{{cidrHost "fd00::/120" 256}}`, nil, "", true},
+		{"cidrHost ipv6 large network", `{{cidrHost "fd00::/8" 65536}}`, nil, "fd00::1:0", false},
+		{"cidrHost invalid", `{{cidrHost "10.0.0.0" 1}}`, nil, "", true},
+		{"cidrNetmask", `{{cidrNetmask "10.0.0.0/20"}}`, nil, "255.255.240.0", false},
+		{"cidrPrefixLen", `{{cidrPrefixLen "fd00::/48"}}`, nil, "48", false},
+		{"ipAdd", `{{ipAdd "10.0.0.1" 2}}`, nil, "10.0.0.3", false},
+		{"ipAdd across octet", `{{ipAdd "10.0.0.255" 1}}`, nil, "10.0.1.0", false},
+		{"ipAdd negative", `{{ipAdd "10.0.1.0" -1}}`, nil, "10.0.0.255", false},
+		{"ipAdd overflow", `{{ipAdd "255.255.255.255" 1}}`, nil, "", true},
+		{"ipAdd underflow", `{{ipAdd "0.0.0.0" -1}}`, nil, "", true},
+		{"ipAdd ipv6", `{{ipAdd "fd00::ffff" 1}}`, nil, "fd00::1:0", false},
+		{"ipAdd ipv6 negative", `{{ipAdd "fd00::1:0" -1}}`, nil, "fd00::ffff", false},
+		{"ipAdd ipv6 overflow", `{{ipAdd "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff" 1}}`, nil, "", true},
+		{"ipAdd ipv6 underflow", `{{ipAdd "::" -1}}`, nil, "", true},
+		{"ipAdd invalid", `{{ipAdd "10.0.0" 1}}`, nil, "", true},
+		{"seq last", `{{range seq 3}}{{.}}{{end}}`, nil, "123", false},
+		{"seq first last", `{{range seq 2 4}}{{.}}{{end}}`, nil, "234", false},
+		{"seq increment", `{{range seq 1 2 7}}{{.}}{{end}}`, nil, "1357", false},
+		{"seq decrement", `{{range seq 3 -1 1}}{{.}}{{end}}`, nil, "321", false},
+		{"seq zero increment", `{{range seq 1 0 3}}{{.}}{{end}}`, nil, "", false},
+		{"seq empty", `{{range seq 0}}{{.}}{{end}}`, nil, "", false},
+		{"until", `{{range until 3}}{{.}}{{end}}`, nil, "012", false},
+		{"default empty", `{{.x | default 22}}`, "", "22", false},
+		{"default zero", `{{.x | default 22}}`, 0, "22", false},
+		{"default missing", `{{.y | default 22}}`, nil, "22", false},
+		{"default set", `{{.x | default 22}}`, 2222, "2222", false},
+		{"toJSON", `{{toJSON .x}}`, map[string]any{"a": []int{1, 2}}, `{"a":[1,2]}`, false},
+		{"toJSON string", `{{toJSON .x}}`, `a"b`, `"a\"b"`, false},
+		{"toJSON unsupported", `{{toJSON .x}}`, func() {}, "", true},
+		{"fromJSON", `{{(fromJSON .x).a}}`, `{"a": "b"}`, "b", false},
+		{"fromJSON invalid", `{{fromJSON .x}}`, `{`, "", true},
+		{"join", `{{.x | join ","}}`, []string{"a", "b"}, "a,b", false},
+		{"join ints", `{{join "-" .x}}`, []int{1, 2, 3}, "1-2-3", false},
+		{"join array", `{{join "" .x}}`, [2]string{"a", "b"}, "ab", false},
+		{"join empty", `{{join "," .x}}`, []string{}, "", false},
+		{"join not a list", `{{join "," .x}}`, "a", "", true},
+		{"split", `{{range split "," .x}}[{{.}}]{{end}}`, "a,b", "[a][b]", false},
+		{"shquote", `{{shquote .x}}`, "it's", `'it'\''s'`, false},
+		{"shquote empty", `{{shquote .x}}`, "", `''`, false},
+		{"shquote number", `{{shquote .x}}`, 22, `'22'`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := *NewScript(test.text)
+			if test.x != nil {
+				script = script.WithField("x", test.x)
+			}
+			compiled, err := script.Compile()
+			if test.err {
+				if err == nil {
+					t.Errorf("expected an error, got %q", compiled.Raw())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			if compiled.Raw() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, compiled.Raw())
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ go 1.19
 require (
 	github.com/antonmedv/expr v1.10.5
 	golang.org/x/crypto v0.0.0-20221010152910-d6f0a8c073c2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
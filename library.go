@@ -0,0 +1,280 @@
+package nescript
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// ScriptLibrary is a named collection of templates (such as shared helper
+// functions or whole scripts) that scripts/cmds using the library can refer
+// to. A template in the library can be used via the template action, e.g.
+// {{template "helpers/net" .}}, or via the include function, e.g. {{include
+// "helpers/net" .}}, which returns the rendered template as a string (and so
+// can be piped to other functions). Templates are parsed when added to the
+// library, whereas references are resolved when compiling, and reference
+// cycles between templates result in a compilation error.
+type ScriptLibrary struct {
+	templates map[string]libraryTemplate
+	lock      sync.RWMutex
+}
+
+// libraryTemplate is a template in a library, along with its parsed trees
+// (including those it defines), or the error from parsing it.
+type libraryTemplate struct {
+	raw   string
+	trees map[string]*parse.Tree
+	err   error
+}
+
+// NewScriptLibrary creates an empty script library.
+func NewScriptLibrary() *ScriptLibrary {
+	return &ScriptLibrary{
+		templates: make(map[string]libraryTemplate),
+	}
+}
+
+// NewScriptLibraryFromFS creates a script library containing every file in the
+// given file system. Each template is named by its path with the extension
+// removed, e.g. the file "helpers/net.sh" is named "helpers/net". This errors
+// if a file can not be read, or two files would have the same name.
+func NewScriptLibraryFromFS(fsys fs.FS) (*ScriptLibrary, error) {
+	library := NewScriptLibrary()
+	err := fs.WalkDir(fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		fileBytes, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filePath, path.Ext(filePath))
+		if library.Has(name) {
+			return fmt.Errorf("multiple files named '%s'", name)
+		}
+		library.Add(name, string(fileBytes))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load script library: %w", err)
+	}
+	return library, nil
+}
+
+// NewScriptLibraryFromDir creates a script library containing every file in
+// the given directory (see NewScriptLibraryFromFS).
+func NewScriptLibraryFromDir(dir string) (*ScriptLibrary, error) {
+	return NewScriptLibraryFromFS(os.DirFS(dir))
+}
+
+// Add adds a template with the given name to the library. If a template with
+// the name already exists, it is replaced. If the template can not be parsed,
+// scripts/cmds using the library fail to compile.
+func (sl *ScriptLibrary) Add(name, raw string) *ScriptLibrary {
+	parsed := libraryTemplate{
+		raw:   raw,
+		trees: make(map[string]*parse.Tree),
+	}
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(raw, "", "", parsed.trees); err != nil {
+		parsed.err = err
+	}
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+	sl.templates[name] = parsed
+	return sl
+}
+
+// Has determines if the library contains a template with the given name.
+func (sl *ScriptLibrary) Has(name string) bool {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	_, ok := sl.templates[name]
+	return ok
+}
+
+// Names returns the sorted names of every template in the library.
+func (sl *ScriptLibrary) Names() []string {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	names := make([]string, 0, len(sl.templates))
+	for name := range sl.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Script creates a new script from the library template with the given name,
+// using the library.
+func (sl *ScriptLibrary) Script(name string) (*Script, error) {
+	sl.lock.RLock()
+	parsed, ok := sl.templates[name]
+	sl.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("script '%s' not found in library", name)
+	}
+	script := NewScript(parsed.raw).WithLibrary(sl)
+	return &script, nil
+}
+
+// addTo adds a copy of every template in the library to the given template
+// set, such that the set can be modified (e.g. escaped) without affecting the
+// library. Functions used by the templates are checked when executed, as they
+// depend on the script/cmd using the library.
+func (sl *ScriptLibrary) addTo(tmpl *template.Template) error {
+	sl.lock.RLock()
+	defer sl.lock.RUnlock()
+	names := make([]string, 0, len(sl.templates))
+	for name := range sl.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parsed := sl.templates[name]
+		if parsed.err != nil {
+			return fmt.Errorf("failed to parse library template '%s': %w", name, parsed.err)
+		}
+		for treeName, tree := range parsed.trees {
+			if _, err := tmpl.AddParseTree(treeName, tree.Copy()); err != nil {
+				return fmt.Errorf("failed to add library template '%s': %w", treeName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// templateReferences returns the names of the templates that the given tree
+// references via the template action or the include function (where the name
+// is a constant).
+func templateReferences(node parse.Node, refs map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateReferences(child, refs)
+		}
+	case *parse.ActionNode:
+		templateReferences(n.Pipe, refs)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateReferences(cmd, refs)
+		}
+	case *parse.CommandNode:
+		if name, ok := includeName(n); ok {
+			refs[name] = true
+		}
+		for _, arg := range n.Args {
+			templateReferences(arg, refs)
+		}
+	case *parse.IfNode:
+		templateReferences(&n.BranchNode, refs)
+	case *parse.RangeNode:
+		templateReferences(&n.BranchNode, refs)
+	case *parse.WithNode:
+		templateReferences(&n.BranchNode, refs)
+	case *parse.BranchNode:
+		templateReferences(n.Pipe, refs)
+		templateReferences(n.List, refs)
+		templateReferences(n.ElseList, refs)
+	case *parse.TemplateNode:
+		refs[n.Name] = true
+		templateReferences(n.Pipe, refs)
+	}
+}
+
+// includeName returns the name of the template included by the command, if
+// the command is a call to include with a constant name.
+func includeName(cmd *parse.CommandNode) (string, bool) {
+	if !isInclude(cmd) || len(cmd.Args) < 2 {
+		return "", false
+	}
+	if name, ok := cmd.Args[1].(*parse.StringNode); ok {
+		return name.Text, true
+	}
+	return "", false
+}
+
+// isInclude determines if the command is a call to the include function.
+func isInclude(cmd *parse.CommandNode) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	return ok && ident.Ident == includeFuncName
+}
+
+// checkTemplateCycles errors if any template in the set references itself,
+// either directly or via other templates.
+func checkTemplateCycles(tmpl *template.Template) error {
+	graph := make(map[string][]string)
+	for _, t := range tmpl.Templates() {
+		refs := make(map[string]bool)
+		if t.Tree != nil {
+			templateReferences(t.Tree.Root, refs)
+		}
+		for ref := range refs {
+			graph[t.Name()] = append(graph[t.Name()], ref)
+		}
+		sort.Strings(graph[t.Name()])
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string, trail []string) error
+	visit = func(name string, trail []string) error {
+		trail = append(trail, name)
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("template reference cycle: %s", strings.Join(quoteNames(trail), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, ref := range graph[name] {
+			if err := visit(ref, trail); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quoteNames quotes each template name for use in an error message.
+func quoteNames(names []string) []string {
+	quoted := make([]string, len(names))
+	for idx, name := range names {
+		quoted[idx] = fmt.Sprintf("%q", name)
+	}
+	return quoted
+}
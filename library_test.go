@@ -0,0 +1,171 @@
+package nescript
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"text/template"
+)
+
+func TestNewScriptLibraryFromFS(t *testing.T) {
+	library, err := NewScriptLibraryFromFS(fstest.MapFS{
+		"greet.sh":       {Data: []byte("echo hello {{.name}}")},
+		"helpers/net.sh": {Data: []byte("ip link show {{.iface}}")},
+		"README":         {Data: []byte("docs")},
+	})
+	if err != nil {
+		t.Fatalf("failed to load library: %v", err)
+	}
+	if expected := []string{"README", "greet", "helpers/net"}; !reflect.DeepEqual(library.Names(), expected) {
+		t.Errorf("expected names %v, got %v", expected, library.Names())
+	}
+	if !library.Has("helpers/net") || library.Has("helpers/net.sh") {
+		t.Error("expected templates to be named by their path without extension")
+	}
+	script, err := library.Script("greet")
+	if err != nil {
+		t.Fatalf("failed to get script: %v", err)
+	}
+	compiled, err := script.WithField("name", "world").Compile()
+	if err != nil {
+		t.Fatalf("failed to compile script: %v", err)
+	}
+	if compiled.Raw() != "echo hello world" {
+		t.Errorf("unexpected script: %q", compiled.Raw())
+	}
+	if _, err := library.Script("missing"); err == nil {
+		t.Error("expected an error for a missing script")
+	}
+}
+
+func TestNewScriptLibraryFromFSDuplicateNames(t *testing.T) {
+	_, err := NewScriptLibraryFromFS(fstest.MapFS{
+		"greet.sh":   {Data: []byte("echo hello")},
+		"greet.bash": {Data: []byte("echo hi")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "greet") {
+		t.Errorf("expected an error naming the duplicate, got: %v", err)
+	}
+}
+
+func TestLibraryReferences(t *testing.T) {
+	library := NewScriptLibrary().
+		Add("greet", "hello {{.name}}").
+		Add("helpers", `{{define "helpers/upper"}}{{upper .}}{{end}}`).
+		Add("nested", `{{template "greet" .}}!`)
+	funcs := template.FuncMap{"upper": strings.ToUpper}
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"template", `echo {{template "greet" .}}`, "echo hello world"},
+		{"include", `echo {{include "greet" .}}`, "echo hello world"},
+		{"include piped", `echo {{include "greet" . | printf "%q"}}`, `echo "hello world"`},
+		{"include with other data", `echo {{include "greet" .node}}`, "echo hello node"},
+		{"nested", `echo {{template "nested" .}}`, "echo hello world!"},
+		{"defined template using script funcs", `echo {{template "helpers/upper" .name}}`, "echo WORLD"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			compiled, err := NewScript(test.text).
+				WithLibrary(library).
+				WithFuncs(funcs).
+				WithField("name", "world").
+				WithField("node", map[string]string{"name": "node"}).
+				Compile()
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			if compiled.Raw() != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, compiled.Raw())
+			}
+		})
+	}
+}
+
+func TestLibraryCmd(t *testing.T) {
+	library := NewScriptLibrary().Add("addr", "{{.host}}:{{.port}}")
+	compiled, err := NewCmd("nc", `{{include "addr" .}}`).
+		WithLibrary(library).
+		WithField("host", "localhost").
+		WithField("port", 22).
+		Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if compiled.Raw()[1] != "localhost:22" {
+		t.Errorf("unexpected arg: %q", compiled.Raw()[1])
+	}
+}
+
+func TestLibraryErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		library *ScriptLibrary
+		text    string
+		err     string
+	}{
+		{"parse error", NewScriptLibrary().Add("bad", "{{.x"), "echo", "bad"},
+		{"missing function", NewScriptLibrary().Add("f", "{{nope .}}"), `{{template "f" .}}`, "nope"},
+		{"missing template", NewScriptLibrary(), `{{include "missing" .}}`, "missing"},
+		{"self reference", NewScriptLibrary().Add("a", `{{template "a" .}}`), "echo", `"a" -> "a"`},
+		{"include cycle", NewScriptLibrary().Add("a", `{{include "b" .}}`).Add("b", `{{template "a" .}}`), "echo", "cycle"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewScript(test.text).WithLibrary(test.library).Compile()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestLibraryIsNotModifiedByUse(t *testing.T) {
+	library := NewScriptLibrary().Add("value", "{{.x}}")
+	script := NewScript(`echo {{template "value" .}}`).WithLibrary(library).WithField("x", "a b")
+	for idx := 0; idx < 2; idx++ {
+		escaped, err := script.WithEscaping(ShellEscaping).Compile()
+		if err != nil {
+			t.Fatalf("failed to compile with escaping: %v", err)
+		}
+		if escaped.Raw() != "echo 'a b'" {
+			t.Errorf("unexpected escaped script: %q", escaped.Raw())
+		}
+		plain, err := script.Compile()
+		if err != nil {
+			t.Fatalf("failed to compile: %v", err)
+		}
+		if plain.Raw() != "echo a b" {
+			t.Errorf("unexpected script: %q", plain.Raw())
+		}
+	}
+}
+
+func TestLibraryConcurrentUse(t *testing.T) {
+	library := NewScriptLibrary().Add("value", "{{.x}}")
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			compiled, err := NewScript(`echo "{{include "value" .}}"`).
+				WithLibrary(library).
+				WithField("x", `"`).
+				WithEscaping(ShellEscaping).
+				Compile()
+			if err != nil {
+				t.Errorf("failed to compile: %v", err)
+				return
+			}
+			if compiled.Raw() != `echo "\""` {
+				t.Errorf("unexpected script: %q", compiled.Raw())
+			}
+		}()
+		library.Add("other", "{{.y}}")
+	}
+	wg.Wait()
+}
@@ -9,9 +9,10 @@ import (
 // Executor returns an exec func that can execute a NEScript locally. A working
 // directory can optionally be specified, where if not, the current working
 // directory of the application is used. This ExecFunc does not require that the
-// cmd/script be converted to a string, so is Formatter agnostic.
+// cmd/script be converted to a string, so is Formatter agnostic. Secret values
+// of the cmd are masked in the output of the process and any errors.
 func Executor(workdir string) nescript.ExecFunc {
-	return func(c nescript.Cmd) (nescript.Process, error) {
+	return nescript.RedactionMiddleware()(func(c nescript.Cmd) (nescript.Process, error) {
 		command, err := c.OSCmd()
 		if err != nil {
 			return nil, err
@@ -21,8 +22,8 @@ func Executor(workdir string) nescript.ExecFunc {
 		}
 		process.cmd.Env = c.Env()
 		process.cmd.Dir = workdir
-		process.cmd.Stdout = &process.stdoutBytes
-		process.cmd.Stderr = &process.stderrBytes
+		process.cmd.Stdout = c.StdoutWriter(&process.stdoutBytes)
+		process.cmd.Stderr = c.StderrWriter(&process.stderrBytes)
 		if stdin, err := process.cmd.StdinPipe(); err != nil {
 			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 		} else {
@@ -32,5 +33,5 @@ func Executor(workdir string) nescript.ExecFunc {
 			return nil, fmt.Errorf("process failed to start: %w", err)
 		}
 		return &process, nil
-	}
+	})
 }
@@ -0,0 +1,36 @@
+package local
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestExecutorRedacts(t *testing.T) {
+	cmd := nescript.NewScript(`echo "token={{.token}} env=$PASSWORD"`).
+		WithSecretField("token", "s3cr3t").
+		WithSecretEnv("PASSWORD=hunter2").
+		MustCompile().
+		Cmd()
+	execs := map[string]func() (nescript.Process, error){
+		"directly":     func() (nescript.Process, error) { return Executor("")(cmd) },
+		"via Cmd.Exec": func() (nescript.Process, error) { return cmd.Exec(Executor("")) },
+	}
+	for name, exec := range execs {
+		t.Run(name, func(t *testing.T) {
+			process, err := exec()
+			if err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			defer process.Close()
+			result := waitResult(t, process)
+			if strings.Contains(result.StdOut, "s3cr3t") || strings.Contains(result.StdOut, "hunter2") {
+				t.Errorf("secrets were not masked: %q", result.StdOut)
+			}
+			if result.StdOut != "token=**** env=****\n" {
+				t.Errorf("unexpected stdout: %q", result.StdOut)
+			}
+		})
+	}
+}
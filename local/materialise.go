@@ -0,0 +1,28 @@
+package local
+
+import (
+	"os"
+
+	"github.com/willfantom/nescript"
+)
+
+// FileMaterialiser writes and removes files on the local file system.
+type FileMaterialiser struct{}
+
+// Materialiser returns a Materialiser for the local file system, allowing for
+// scripts to be executed as files by the local executor (see
+// nescript.Script.ExecFile).
+func Materialiser() nescript.Materialiser {
+	return FileMaterialiser{}
+}
+
+func (m FileMaterialiser) WriteFile(path string, content []byte, perm os.FileMode) error {
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
+
+func (m FileMaterialiser) Remove(path string) error {
+	return os.Remove(path)
+}
@@ -0,0 +1,58 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestMaterialiser(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := Materialiser().WriteFile(path, []byte("content"), 0700); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("file was not written with its permissions: %v", err)
+	}
+	if err := Materialiser().Remove(path); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file was not removed: %v", err)
+	}
+}
+
+func TestExecFile(t *testing.T) {
+	dir := t.TempDir()
+	script := nescript.NewScript("#!/bin/sh\necho \"$0\"").MustCompile()
+	process, err := script.ExecFile(Executor(""), Materialiser(), dir)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result := waitResult(t, process)
+	if filepath.Dir(result.StdOut) != dir {
+		t.Errorf("script was not executed from a file in %s: %q", dir, result.StdOut)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("file was not removed")
+	}
+}
+
+func TestExecFileRemovesFileOnKill(t *testing.T) {
+	dir := t.TempDir()
+	script := nescript.NewScript("exec sleep 10").MustCompile()
+	process, err := script.ExecFile(Executor(""), Materialiser(), dir)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatalf("failed to kill: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("file was not removed")
+	}
+	process.Result()
+}
@@ -0,0 +1,166 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/willfantom/nescript"
+)
+
+// PipelineExecutor returns a pipeline exec func that executes each stage of a
+// pipeline as a local process, connecting the stdout of each stage directly to
+// the stdin of the next (without the use of a shell). A working directory can
+// optionally be specified, which is used by every stage.
+func PipelineExecutor(workdir string) nescript.PipelineExecFunc {
+	return func(p nescript.Pipeline) (nescript.Process, error) {
+		stages := p.Stages()
+		if len(stages) == 0 {
+			return nil, fmt.Errorf("pipeline has no stages")
+		}
+		process := LocalPipelineProcess{
+			cmds: make([]*exec.Cmd, len(stages)),
+		}
+		for idx, stage := range stages {
+			command, err := stage.OSCmd()
+			if err != nil {
+				return nil, err
+			}
+			command.Env = stage.Env()
+			command.Dir = workdir
+			command.Stderr = stage.StderrWriter(&process.stderr)
+			process.cmds[idx] = command
+		}
+		process.cmds[len(stages)-1].Stdout = stages[len(stages)-1].StdoutWriter(&process.stdoutBytes)
+		if stdin, err := process.cmds[0].StdinPipe(); err != nil {
+			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+		} else {
+			process.stdin = stdin
+		}
+		pipeEnds := make([]*os.File, 0)
+		defer func() {
+			for _, end := range pipeEnds {
+				end.Close()
+			}
+		}()
+		for idx := 0; idx < len(stages)-1; idx++ {
+			reader, writer, err := os.Pipe()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create pipe between stages: %w", err)
+			}
+			pipeEnds = append(pipeEnds, reader, writer)
+			process.cmds[idx].Stdout = writer
+			process.cmds[idx+1].Stdin = reader
+		}
+		for idx, command := range process.cmds {
+			if err := command.Start(); err != nil || command.Process == nil {
+				for _, started := range process.cmds[:idx] {
+					started.Process.Kill()
+					started.Wait()
+				}
+				return nil, fmt.Errorf("pipeline stage %d failed to start: %w", idx, err)
+			}
+		}
+		return &process, nil
+	}
+}
+
+// LocalPipelineProcess represents the processes of every stage of a pipeline
+// running or completed on the local device.
+type LocalPipelineProcess struct {
+	cmds        []*exec.Cmd
+	stdin       io.WriteCloser
+	stdoutBytes bytes.Buffer
+	stderr      lockedBuffer
+}
+
+// Kill kills the process of every stage of the pipeline.
+func (p *LocalPipelineProcess) Kill() error {
+	for idx, command := range p.cmds {
+		if err := command.Process.Kill(); err != nil && err != os.ErrProcessDone {
+			return fmt.Errorf("failed to kill pipeline stage %d: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// Signal sends the signal to the process of every stage of the pipeline, as a
+// shell would for a foreground pipeline.
+func (p *LocalPipelineProcess) Signal(s os.Signal) error {
+	for idx, command := range p.cmds {
+		if err := command.Process.Signal(s); err != nil && err != os.ErrProcessDone {
+			return fmt.Errorf("failed to send signal to pipeline stage %d: %w", idx, err)
+		}
+	}
+	return nil
+}
+
+// Write writes to the stdin of the first stage of the pipeline.
+func (p *LocalPipelineProcess) Write(input string) error {
+	if _, err := io.WriteString(p.stdin, input); err != nil {
+		return fmt.Errorf("failed to write to stdin: %w", err)
+	}
+	return nil
+}
+
+// Result waits for every stage of the pipeline to exit. As no more input can
+// be written once waiting, the stdin of the first stage is closed first, such
+// that stages reading from stdin (e.g. "cat | wc -l") see the end of their
+// input. The exit code of the result is that of the last stage, whilst the
+// exit code of every stage is given in PipeStatus. Every stage is waited for
+// even if waiting for an earlier stage fails, in which case the first such
+// error is returned.
+func (p *LocalPipelineProcess) Result() (*nescript.Result, error) {
+	p.stdin.Close()
+	var waitErr error
+	statuses := make([]int, len(p.cmds))
+	for idx, command := range p.cmds {
+		if err := command.Wait(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok && waitErr == nil {
+				waitErr = fmt.Errorf("failed to wait for pipeline stage %d: %w", idx, err)
+			}
+		}
+		statuses[idx] = command.ProcessState.ExitCode()
+	}
+	if waitErr != nil {
+		return nil, waitErr
+	}
+	result := nescript.Result{
+		StdOut:     p.stdoutBytes.String(),
+		StdErr:     p.stderr.String(),
+		ExitCode:   statuses[len(statuses)-1],
+		PipeStatus: statuses,
+	}
+	for idx, command := range p.cmds {
+		if err := command.Process.Release(); err != nil {
+			return nil, fmt.Errorf("failed to release resources of pipeline stage %d: %w", idx, err)
+		}
+	}
+	return &result, nil
+}
+
+func (p *LocalPipelineProcess) Close() {
+	// nothing to close
+}
+
+// lockedBuffer is a buffer that can be written to by multiple processes
+// concurrently.
+type lockedBuffer struct {
+	buffer bytes.Buffer
+	lock   sync.Mutex
+}
+
+func (lb *lockedBuffer) Write(b []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buffer.Write(b)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buffer.String()
+}
@@ -0,0 +1,153 @@
+package local
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willfantom/nescript"
+)
+
+// waitResult waits for the result of the process, failing the test if it does
+// not complete in time.
+func waitResult(t *testing.T, process nescript.Process) *nescript.Result {
+	t.Helper()
+	type outcome struct {
+		result *nescript.Result
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := process.Result()
+		done <- outcome{result, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			t.Fatalf("failed to get result: %v", o.err)
+		}
+		return o.result
+	case <-time.After(5 * time.Second):
+		process.Kill()
+		t.Fatal("process did not complete")
+	}
+	return nil
+}
+
+func TestPipelineExecutorClosesStdin(t *testing.T) {
+	pipeline := nescript.NewPipeline(*nescript.NewCmd("cat"), *nescript.NewCmd("wc", "-l"))
+	process, err := pipeline.Exec(PipelineExecutor(""))
+	if err != nil {
+		t.Fatalf("failed to execute pipeline: %v", err)
+	}
+	if err := process.Write("a\nb\n"); err != nil {
+		t.Fatalf("failed to write to pipeline: %v", err)
+	}
+	result := waitResult(t, process)
+	if strings.TrimSpace(result.StdOut) != "2" {
+		t.Errorf("unexpected output: %q", result.StdOut)
+	}
+}
+
+func TestShellPipelineKeepsSecretEnvOffArgv(t *testing.T) {
+	pipeline := nescript.NewPipeline(
+		nescript.NewCmd("sh", "-c", `echo "${#TOKEN} $PUBLIC"`).WithSecretEnv("TOKEN=s3cr3t-value").WithEnv("PUBLIC=it's"),
+		*nescript.NewCmd("cat"),
+	)
+	cmd, err := pipeline.Cmd()
+	if err != nil {
+		t.Fatalf("failed to convert pipeline: %v", err)
+	}
+	if argv := strings.Join(cmd.Raw(), " "); strings.Contains(argv, "s3cr3t-value") {
+		t.Errorf("secret env var is on argv: %s", argv)
+	}
+	if formatted := cmd.Formatted(); strings.Contains(formatted, "s3cr3t-value") {
+		t.Errorf("secret env var is formatted: %s", formatted)
+	}
+	process, err := pipeline.Exec(nescript.ShellPipelineExecutor(Executor("")))
+	if err != nil {
+		t.Fatalf("failed to execute pipeline: %v", err)
+	}
+	result := waitResult(t, process)
+	if result.StdOut != "12 it's\n" {
+		t.Errorf("env vars were not given to the stage: %q (stderr %q)", result.StdOut, result.StdErr)
+	}
+	if len(result.PipeStatus) != 2 || result.ExitCode != 0 {
+		t.Errorf("unexpected statuses: %v %d", result.PipeStatus, result.ExitCode)
+	}
+}
+
+func TestShellPipelineMissingStageStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		stages   []nescript.Cmd
+		statuses []int
+		exitCode int
+	}{
+		{
+			"first stage",
+			[]nescript.Cmd{*nescript.NewCmd("sh", "-c", "kill -KILL $PPID"), *nescript.NewCmd("sh", "-c", "cat; exit 3")},
+			[]int{-1, 3},
+			3,
+		},
+		{
+			"middle stage",
+			[]nescript.Cmd{*nescript.NewCmd("echo", "a"), *nescript.NewCmd("sh", "-c", "kill -KILL $PPID"), *nescript.NewCmd("true")},
+			[]int{0, -1, 0},
+			0,
+		},
+		{
+			"last stage",
+			[]nescript.Cmd{*nescript.NewCmd("true"), *nescript.NewCmd("sh", "-c", "kill -KILL $PPID")},
+			[]int{0, -1},
+			125,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			process, err := nescript.NewPipeline(test.stages...).Exec(nescript.ShellPipelineExecutor(Executor("")))
+			if err != nil {
+				t.Fatalf("failed to execute pipeline: %v", err)
+			}
+			result := waitResult(t, process)
+			if !reflect.DeepEqual(result.PipeStatus, test.statuses) {
+				t.Errorf("expected statuses %v, got %v (stderr %q)", test.statuses, result.PipeStatus, result.StdErr)
+			}
+			if result.ExitCode != test.exitCode {
+				t.Errorf("expected exit code %d, got %d", test.exitCode, result.ExitCode)
+			}
+		})
+	}
+}
+
+// failingWriter is a writer that always errors.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestPipelineResultWaitsForEveryStage(t *testing.T) {
+	first := exec.Command("sh", "-c", "echo oops >&2")
+	first.Stderr = failingWriter{}
+	last := exec.Command("sh", "-c", "sleep 0.2")
+	stdin, err := first.StdinPipe()
+	if err != nil {
+		t.Fatalf("failed to create stdin pipe: %v", err)
+	}
+	process := &LocalPipelineProcess{cmds: []*exec.Cmd{first, last}, stdin: stdin}
+	for _, command := range process.cmds {
+		if err := command.Start(); err != nil {
+			t.Fatalf("failed to start stage: %v", err)
+		}
+	}
+	if _, err := process.Result(); err == nil || !strings.Contains(err.Error(), "stage 0") {
+		t.Errorf("expected an error waiting for stage 0, got: %v", err)
+	}
+	if last.ProcessState == nil {
+		t.Error("expected the last stage to be waited for")
+	}
+}
@@ -61,6 +61,7 @@ func (p *LocalProcess) Result() (*nescript.Result, error) {
 		StdErr: string(p.stderrBytes.String()),
 	}
 	result.ExitCode = p.cmd.ProcessState.ExitCode()
+	result.Usage = usage(p.cmd.ProcessState)
 	if err := p.cmd.Process.Release(); err != nil {
 		return nil, fmt.Errorf("failed to release to process resources: %w", err)
 	}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package local
+
+import (
+	"os"
+
+	"github.com/willfantom/nescript"
+)
+
+// usage extracts the CPU times of an exited process from its state. Other
+// figures are not available on this platform.
+func usage(state *os.ProcessState) *nescript.Usage {
+	if state == nil {
+		return nil
+	}
+	return &nescript.Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+}
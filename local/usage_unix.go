@@ -0,0 +1,32 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package local
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/willfantom/nescript"
+)
+
+// usage extracts the resource usage of an exited process from its state. The
+// max RSS is reported in bytes on darwin, but in kilobytes elsewhere.
+func usage(state *os.ProcessState) *nescript.Usage {
+	if state == nil {
+		return nil
+	}
+	u := nescript.Usage{
+		UserTime:   state.UserTime(),
+		SystemTime: state.SystemTime(),
+	}
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok && rusage != nil {
+		u.MaxRSS = int64(rusage.Maxrss)
+		if runtime.GOOS != "darwin" {
+			u.MaxRSS *= 1024
+		}
+		u.VoluntaryContextSwitches = int64(rusage.Nvcsw)
+		u.InvoluntaryContextSwitches = int64(rusage.Nivcsw)
+	}
+	return &u
+}
@@ -0,0 +1,97 @@
+package nescript
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Materialiser writes files to, and removes files from, the system that an
+// executor executes on. This allows for a script to be written to a file on
+// the target and executed directly (see Script.ExecFile).
+type Materialiser interface {
+	// WriteFile writes the content to the file at the given path on the target,
+	// creating or truncating it, with the given permissions.
+	WriteFile(path string, content []byte, perm os.FileMode) error
+
+	// Remove deletes the file at the given path on the target.
+	Remove(path string) error
+}
+
+var (
+	// defaultMaterialiseDir is the directory on the target that scripts are
+	// written to if no directory is given.
+	defaultMaterialiseDir string = "/tmp"
+)
+
+// ExecFile writes the script to an executable temporary file in the given
+// directory on the target (or /tmp if empty) using the materialiser, then
+// executes the file directly using the given ExecFunc. This allows for scripts
+// to make use of a shebang. If the script does not start with a shebang, the
+// file is instead passed as an argument to the script's subcommand (with any
+// trailing "-c" removed), e.g. ["sh", "/tmp/nescript-..."]. The file is removed
+// once a result is obtained from the process, or the process is killed or
+// closed, so that it is not left behind if the process is abandoned. Removal
+// is best-effort, so any error removing the file is ignored. This does not
+// compile the script first.
+func (s Script) ExecFile(executor ExecFunc, materialiser Materialiser, dir string) (Process, error) {
+	if dir == "" {
+		dir = defaultMaterialiseDir
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate script file name: %w", err)
+	}
+	filePath := path.Join(dir, "nescript-"+hex.EncodeToString(suffix))
+	if err := materialiser.WriteFile(filePath, []byte(s.raw), 0700); err != nil {
+		return nil, fmt.Errorf("failed to write script to '%s': %w", filePath, err)
+	}
+	once := sync.Once{}
+	cleanup := func() {
+		once.Do(func() { materialiser.Remove(filePath) })
+	}
+	process, err := s.fileCmd(filePath).Exec(executor)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &hookedProcess{
+		Process: process,
+		onResult: func(r *Result, err error) (*Result, error) {
+			cleanup()
+			return r, err
+		},
+		onKill:  cleanup,
+		onClose: cleanup,
+	}, nil
+}
+
+// CompileExecFile compiles the script using the given data and the golang
+// template system, then calls ExecFile.
+func (s Script) CompileExecFile(executor ExecFunc, materialiser Materialiser, dir string) (Process, error) {
+	cs, err := s.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return cs.ExecFile(executor, materialiser, dir)
+}
+
+// fileCmd creates the cmd used to execute the script once written to the file
+// at the given path.
+func (s Script) fileCmd(filePath string) Cmd {
+	command := []string{filePath}
+	if !strings.HasPrefix(s.raw, "#!") {
+		subcommand := s.subcommand
+		if len(subcommand) > 0 && subcommand[len(subcommand)-1] == "-c" {
+			subcommand = subcommand[:len(subcommand)-1]
+		}
+		command = append(append([]string{}, subcommand...), filePath)
+	}
+	cmd := NewCmd(command[0], command[1:]...)
+	cmd.dynamicData = s.dynamicData
+	return *cmd
+}
@@ -0,0 +1,107 @@
+package nescript
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fakeMaterialiser holds the files written to a fake target.
+type fakeMaterialiser struct {
+	files   map[string]string
+	removed []string
+}
+
+func (m *fakeMaterialiser) WriteFile(path string, content []byte, perm os.FileMode) error {
+	m.files[path] = string(content)
+	return nil
+}
+
+func (m *fakeMaterialiser) Remove(path string) error {
+	delete(m.files, path)
+	m.removed = append(m.removed, path)
+	return nil
+}
+
+func TestExecFileCmd(t *testing.T) {
+	tests := []struct {
+		name   string
+		script Script
+		argv   []string
+	}{
+		{"shebang", *NewScript("#!/bin/bash\necho hi"), []string{"/work/"}},
+		{"subcommand", NewScript("echo hi").WithSubcommand(SCBash), []string{"bash", "/work/"}},
+		{"python", NewScript("print(1)").WithSubcommand(SCPython3), []string{"python3", "/work/"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			materialiser := &fakeMaterialiser{files: make(map[string]string)}
+			cmds := make([]Cmd, 0)
+			executor := fakeExecutor(&cmds, func(c Cmd) (Process, error) {
+				return &fakeProcess{result: &Result{}}, nil
+			})
+			if _, err := test.script.ExecFile(executor, materialiser, "/work"); err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			argv := cmds[0].Raw()
+			if len(argv) != len(test.argv) || !strings.HasPrefix(argv[len(argv)-1], "/work/nescript-") {
+				t.Fatalf("unexpected argv: %q", argv)
+			}
+			for idx := range argv[:len(argv)-1] {
+				if argv[idx] != test.argv[idx] {
+					t.Errorf("unexpected argv: %q", argv)
+				}
+			}
+			if content := materialiser.files[argv[len(argv)-1]]; content != test.script.Raw() {
+				t.Errorf("unexpected file content: %q", content)
+			}
+		})
+	}
+}
+
+func TestExecFileRemovesFile(t *testing.T) {
+	tests := map[string]func(Process){
+		"result": func(p Process) { p.Result() },
+		"kill":   func(p Process) { p.Kill() },
+		"close":  func(p Process) { p.Close() },
+		"all": func(p Process) {
+			p.Kill()
+			p.Result()
+			p.Close()
+		},
+	}
+	for name, done := range tests {
+		t.Run(name, func(t *testing.T) {
+			materialiser := &fakeMaterialiser{files: make(map[string]string)}
+			cmds := make([]Cmd, 0)
+			executor := fakeExecutor(&cmds, func(c Cmd) (Process, error) {
+				return &fakeProcess{result: &Result{}}, nil
+			})
+			process, err := NewScript("echo hi").ExecFile(executor, materialiser, "")
+			if err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			if len(materialiser.files) != 1 || len(materialiser.removed) != 0 {
+				t.Fatalf("file was not written, or removed early")
+			}
+			done(process)
+			if len(materialiser.files) != 0 || len(materialiser.removed) != 1 {
+				t.Errorf("file was not removed exactly once: %v", materialiser.removed)
+			}
+		})
+	}
+	t.Run("exec error", func(t *testing.T) {
+		materialiser := &fakeMaterialiser{files: make(map[string]string)}
+		cmds := make([]Cmd, 0)
+		executor := fakeExecutor(&cmds, func(c Cmd) (Process, error) {
+			return nil, errors.New("failed")
+		})
+		if _, err := NewScript("echo hi").ExecFile(executor, materialiser, ""); err == nil {
+			t.Fatal("expected an error")
+		}
+		if len(materialiser.files) != 0 || !strings.HasPrefix(materialiser.removed[0], "/tmp/nescript-") {
+			t.Errorf("file was not removed: %v", materialiser.removed)
+		}
+	})
+}
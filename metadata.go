@@ -0,0 +1,307 @@
+package nescript
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScriptMetadata is configuration that can be provided alongside a script when
+// it is loaded, so that scripts can be self-describing. Metadata can either be
+// given in a front-matter block at the top of the script (see
+// ParseFrontMatter), or in a separate file (see NewScriptFromFS).
+type ScriptMetadata struct {
+	// Subcommand is used as the script's subcommand (see Script.WithSubcommand).
+	Subcommand Subcommand `json:"subcommand,omitempty" yaml:"subcommand,omitempty"`
+
+	// Env vars in KEY=VALUE format that are added to the script.
+	Env []string `json:"env,omitempty" yaml:"env,omitempty"`
+
+	// Fields are added to the script's template data.
+	Fields map[string]any `json:"fields,omitempty" yaml:"fields,omitempty"`
+
+	// Inputs declare the fields the script expects. Defaults are added to the
+	// script's template data, and the script's data is validated against the
+	// inputs when compiled.
+	Inputs map[string]InputSpec `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+
+	// Outputs declare the outputs the script is expected to set (see
+	// ValidateOutput).
+	Outputs map[string]OutputSpec `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+
+	// Timeout is the maximum time the script is expected to take to execute.
+	// This is informational, and it is up to the caller to enforce it.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+// InputSpec declares a field expected by a script. The type can be string,
+// int, float, bool, list or map (or empty for any type).
+type InputSpec struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+// OutputSpec declares an output expected to be set by a script. The type can
+// be string, int or json (as with set-output), or empty for any type.
+type OutputSpec struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+const (
+	// metadataSuffix is appended to the file name of a script to give the file
+	// name of its metadata, e.g. setup.sh and setup.sh.meta.json.
+	metadataSuffix string = ".meta.json"
+)
+
+var (
+	// frontMatterStartRegex matches the line that starts a front-matter block,
+	// capturing the comment prefix used, e.g. "# ---" or "// ---". A comment
+	// prefix is required, so that scripts starting with a YAML document marker
+	// or a command (e.g. "echo ---") are not mistaken for front-matter.
+	frontMatterStartRegex *regexp.Regexp = regexp.MustCompile(`^(#|//|--|;)\s+---\s*$`)
+)
+
+// ParseFrontMatter extracts the metadata from the front-matter block of a
+// script, if it has one. The block must be at the start of the script (after
+// an optional shebang line), and is YAML where each line is prefixed with the
+// comment syntax of the script, which is one of #, //, -- or ;. The block
+// starts and ends with a line of "---" after the prefix and a space. For
+// example:
+//
+//	#!/bin/bash
+//	# ---
+//	# subcommand: [bash, -c]
+//	# inputs:
+//	#   iface: {type: string, required: true}
+//	#   count: {type: int, default: 3}
+//	# outputs:
+//	#   latency: {type: int}
+//	# timeout: 30s
+//	# ---
+//
+// Nil is returned if the script has no front-matter. This errors if the block
+// is not terminated, is not valid YAML, or is not valid metadata.
+func ParseFrontMatter(raw string) (*ScriptMetadata, error) {
+	lines := strings.Split(raw, "\n")
+	start := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "#!") {
+		start = 1
+	}
+	if start >= len(lines) {
+		return nil, nil
+	}
+	match := frontMatterStartRegex.FindStringSubmatch(strings.TrimRight(lines[start], "\r"))
+	if match == nil {
+		return nil, nil
+	}
+	prefix := match[1]
+	body := make([]string, 0)
+	for _, line := range lines[start+1:] {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, prefix) {
+			return nil, fmt.Errorf("front-matter line '%s' does not start with '%s'", line, prefix)
+		}
+		line = strings.TrimPrefix(line, prefix)
+		if strings.TrimSpace(line) == "---" {
+			return parseMetadata([]byte(strings.Join(body, "\n")))
+		}
+		body = append(body, strings.TrimPrefix(line, " "))
+	}
+	return nil, fmt.Errorf("front-matter is not terminated with '%s ---'", prefix)
+}
+
+// parseMetadata parses YAML (or JSON) encoded script metadata, validating it.
+func parseMetadata(raw []byte) (*ScriptMetadata, error) {
+	var metadata ScriptMetadata
+	if err := yaml.Unmarshal(raw, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse script metadata: %w", err)
+	}
+	if err := metadata.validate(); err != nil {
+		return nil, fmt.Errorf("invalid script metadata: %w", err)
+	}
+	return &metadata, nil
+}
+
+// validate checks that the declared types are known, and that defaults match
+// their declared types.
+func (sm ScriptMetadata) validate() error {
+	for _, name := range sortedKeys(sm.Inputs) {
+		input := sm.Inputs[name]
+		if !isKnownInputType(input.Type) {
+			return fmt.Errorf("input '%s' has unknown type '%s'", name, input.Type)
+		}
+		if input.Default != nil {
+			if input.Required {
+				return fmt.Errorf("input '%s' is required so can not have a default", name)
+			}
+			if err := input.check(input.Default); err != nil {
+				return fmt.Errorf("default of input '%s' is invalid: %w", name, err)
+			}
+		}
+	}
+	for _, name := range sortedKeys(sm.Outputs) {
+		switch strings.ToLower(sm.Outputs[name].Type) {
+		case "", "string", "int", "json":
+		default:
+			return fmt.Errorf("output '%s' has unknown type '%s'", name, sm.Outputs[name].Type)
+		}
+	}
+	for _, e := range sm.Env {
+		if !strings.Contains(e, "=") {
+			return fmt.Errorf("env var '%s' is not in KEY=VALUE format", e)
+		}
+	}
+	return nil
+}
+
+// ValidateFields checks that every required input is present in the given
+// template data, and that the values of declared inputs match their types.
+func (sm ScriptMetadata) ValidateFields(data map[string]any) error {
+	for _, name := range sortedKeys(sm.Inputs) {
+		input := sm.Inputs[name]
+		value, ok := data[name]
+		if !ok {
+			if input.Required {
+				return fmt.Errorf("required input '%s' is not set", name)
+			}
+			continue
+		}
+		if err := input.check(value); err != nil {
+			return fmt.Errorf("input '%s' is invalid: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateOutput checks that every declared output is present in the given
+// output, and that the values match their declared types.
+func (sm ScriptMetadata) ValidateOutput(output Output) error {
+	for _, name := range sortedKeys(sm.Outputs) {
+		value, ok := output[name]
+		if !ok {
+			return fmt.Errorf("expected output '%s' was not set", name)
+		}
+		switch strings.ToLower(sm.Outputs[name].Type) {
+		case "string":
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("output '%s' is not a string", name)
+			}
+		case "int":
+			if _, ok := value.(int); !ok {
+				return fmt.Errorf("output '%s' is not an int", name)
+			}
+		}
+	}
+	return nil
+}
+
+// merge returns the metadata with any values set in other replacing its own.
+func (sm ScriptMetadata) merge(other ScriptMetadata) ScriptMetadata {
+	if other.Subcommand != nil {
+		sm.Subcommand = other.Subcommand
+	}
+	sm.Env = append(append([]string{}, sm.Env...), other.Env...)
+	sm.Fields = mergeMaps(sm.Fields, other.Fields)
+	sm.Inputs = mergeMaps(sm.Inputs, other.Inputs)
+	sm.Outputs = mergeMaps(sm.Outputs, other.Outputs)
+	if other.Timeout != 0 {
+		sm.Timeout = other.Timeout
+	}
+	return sm
+}
+
+// check determines if the value is of the input's type.
+func (is InputSpec) check(value any) error {
+	var kinds []reflect.Kind
+	switch strings.ToLower(is.Type) {
+	case "":
+		return nil
+	case "string":
+		kinds = []reflect.Kind{reflect.String}
+	case "int":
+		kinds = []reflect.Kind{reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64}
+	case "float":
+		kinds = []reflect.Kind{reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64}
+	case "bool":
+		kinds = []reflect.Kind{reflect.Bool}
+	case "list":
+		kinds = []reflect.Kind{reflect.Slice, reflect.Array}
+	case "map":
+		kinds = []reflect.Kind{reflect.Map, reflect.Struct}
+	}
+	if value != nil {
+		kind := reflect.TypeOf(value).Kind()
+		for _, k := range kinds {
+			if kind == k {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("value of type %T is not a valid %s", value, is.Type)
+}
+
+func isKnownInputType(t string) bool {
+	switch strings.ToLower(t) {
+	case "", "string", "int", "float", "bool", "list", "map":
+		return true
+	}
+	return false
+}
+
+// Metadata returns the metadata the script was loaded with, or nil if it has
+// none.
+func (s Script) Metadata() *ScriptMetadata {
+	return s.metadata
+}
+
+// withMetadata sets the script's metadata, applying its subcommand, env vars,
+// fields and input defaults to the script.
+func (s Script) withMetadata(metadata *ScriptMetadata) Script {
+	if metadata == nil {
+		return s
+	}
+	s.metadata = metadata
+	s.options.inputs = metadata.Inputs
+	if metadata.Subcommand != nil {
+		s.subcommand = metadata.Subcommand
+	}
+	s.addEnv(metadata.Env...)
+	s.addFields(metadata.Fields, true)
+	for name, input := range metadata.Inputs {
+		if input.Default != nil {
+			s.addFields(map[string]any{name: input.Default}, false)
+		}
+	}
+	return s
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mergeMaps[V any](a, b map[string]V) map[string]V {
+	if a == nil && b == nil {
+		return nil
+	}
+	merged := make(map[string]V, len(a)+len(b))
+	for k, v := range a {
+		merged[k] = v
+	}
+	for k, v := range b {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,88 @@
+package nescript
+
+import (
+	"testing"
+)
+
+func TestParseFrontMatter(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		metadata bool
+	}{
+		{"hash prefix", "# ---\n# timeout: 5s\n# ---\necho hi", true},
+		{"slash prefix after shebang", "#!/usr/bin/env node\n// ---\n// timeout: 5s\n// ---\n", true},
+		{"dash prefix", "-- ---\n-- timeout: 5s\n-- ---\nselect 1;", true},
+		{"semicolon prefix", "; ---\n; timeout: 5s\n; ---\n", true},
+		{"command", "echo ---\necho hi", false},
+		{"command after shebang", "#!/bin/sh\nprintf ---\n", false},
+		{"yaml document", "---\nsome: yaml\n", false},
+		{"yaml document after shebang", "#!/bin/cat\n---\nsome: yaml\n", false},
+		{"long rule", "-----\ntext\n", false},
+		{"no front-matter", "echo hi", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := ParseFrontMatter(test.raw)
+			if err != nil {
+				t.Fatalf("failed to parse front-matter: %v", err)
+			}
+			if (metadata != nil) != test.metadata {
+				t.Errorf("expected metadata: %v, got %+v", test.metadata, metadata)
+			}
+		})
+	}
+}
+
+func TestYAMLScriptCompiles(t *testing.T) {
+	script, err := NewScript("---\nsome: yaml\n").Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if script.Raw() != "---\nsome: yaml\n" {
+		t.Errorf("unexpected script: %q", script.Raw())
+	}
+}
+
+func TestCommandScriptCompiles(t *testing.T) {
+	script, err := NewScript("echo ---\necho hi").Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if script.Raw() != "echo ---\necho hi" {
+		t.Errorf("unexpected script: %q", script.Raw())
+	}
+}
+
+func TestInputTypes(t *testing.T) {
+	tests := []struct {
+		input string
+		value any
+		valid bool
+	}{
+		{"float", 1.5, true},
+		{"float", float32(1.5), true},
+		{"float", 1, true},
+		{"float", int8(1), true},
+		{"float", int32(1), true},
+		{"float", uint(1), true},
+		{"float", uint64(1), true},
+		{"float", "1.5", false},
+		{"int", int16(1), true},
+		{"int", uint8(1), true},
+		{"int", 1.5, false},
+		{"string", "a", true},
+		{"string", 1, false},
+		{"bool", true, true},
+		{"list", []string{"a"}, true},
+		{"map", map[string]int{"a": 1}, true},
+		{"", struct{}{}, true},
+	}
+	for _, test := range tests {
+		metadata := ScriptMetadata{Inputs: map[string]InputSpec{"x": {Type: test.input}}}
+		err := metadata.ValidateFields(map[string]any{"x": test.value})
+		if (err == nil) != test.valid {
+			t.Errorf("expected %T to be valid for %q: %v, got: %v", test.value, test.input, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package nescript
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps an ExecFunc, returning a new ExecFunc that adds some
+// behavior around the execution of a cmd/script. This allows for cross-cutting
+// behavior (logging, timing, locking, etc...) to be applied to any executor,
+// rather than being implemented in each executor individually.
+type Middleware func(ExecFunc) ExecFunc
+
+const (
+	// redactionMask replaces secret values wherever they are redacted.
+	redactionMask string = "****"
+)
+
+// Chain composes multiple middlewares into a single middleware. The first
+// middleware given is the outermost, thus is the first to see the cmd when
+// executed and the last to see the resulting process.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next ExecFunc) ExecFunc {
+		for idx := len(middleware) - 1; idx >= 0; idx-- {
+			if middleware[idx] != nil {
+				next = middleware[idx](next)
+			}
+		}
+		return next
+	}
+}
+
+// With wraps the ExecFunc with the given middlewares. The first middleware
+// given is the outermost.
+func (e ExecFunc) With(middleware ...Middleware) ExecFunc {
+	return Chain(middleware...)(e)
+}
+
+// LoggingMiddleware logs the (formatted) cmd when it is executed, along with
+// the exit code once a result is obtained from the process. Any execution
+// errors are also logged. If the logger is nil, the standard logger is used.
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			logger.Printf("executing: %s", c.String())
+			process, err := next(c)
+			if err != nil {
+				logger.Printf("failed to execute: %s: %v", c.String(), err)
+				return nil, err
+			}
+			return &hookedProcess{
+				Process: process,
+				onResult: func(r *Result, err error) (*Result, error) {
+					if err != nil {
+						logger.Printf("failed to get result: %s: %v", c.String(), err)
+					} else {
+						logger.Printf("completed with exit code %d: %s", r.ExitCode, c.String())
+					}
+					return r, err
+				},
+			}, nil
+		}
+	}
+}
+
+// RedactionMiddleware replaces any occurrence of the given secret values, and
+// the secret values of the cmd being executed (see Cmd.WithSecretField), with
+// a mask in the stdout and stderr of a result (and any of its attempts), as
+// well as in any error returned by the executor or the process. The executors
+// provided by this module apply this themselves, so custom executors should
+// also apply it to mask the secrets of the cmds they execute.
+func RedactionMiddleware(secrets ...string) Middleware {
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			secrets := append(append([]string{}, secrets...), c.Secrets()...)
+			if len(secrets) == 0 {
+				return next(c)
+			}
+			process, err := next(c)
+			if err != nil {
+				return nil, redactError(err, secrets)
+			}
+			return withRedaction(process, secrets), nil
+		}
+	}
+}
+
+// TimingMiddleware sets the TotalTime of a result to the duration between the
+// cmd being passed to the executor and the result being obtained from the
+// process.
+func TimingMiddleware() Middleware {
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			start := time.Now()
+			process, err := next(c)
+			if err != nil {
+				return nil, err
+			}
+			return &hookedProcess{
+				Process: process,
+				onResult: func(r *Result, err error) (*Result, error) {
+					if err == nil {
+						r.TotalTime = time.Since(start)
+					}
+					return r, err
+				},
+			}, nil
+		}
+	}
+}
+
+// ConcurrencyLimitMiddleware limits the number of processes that can be
+// running via the wrapped executor at any one time. Executing a cmd will block
+// until a slot is available. A slot is freed once a result is obtained from
+// the process, or the process is closed.
+func ConcurrencyLimitMiddleware(limit int) Middleware {
+	if limit <= 0 {
+		limit = 1
+	}
+	slots := make(chan struct{}, limit)
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			slots <- struct{}{}
+			return holdUntilDone(next, c, func() { <-slots })
+		}
+	}
+}
+
+// TargetLocks is a set of locks, one for each target key (e.g. an SSH address
+// or container ID), used by the ExclusiveMiddleware. Only the locks of targets
+// that are in use are held, so a set can be used for any number of targets.
+type TargetLocks struct {
+	lock  sync.Mutex
+	locks map[string]*targetLock
+}
+
+// targetLock is the lock of a single target, along with the number of
+// executions holding or waiting for it.
+type targetLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+// NewTargetLocks creates an empty set of target locks.
+func NewTargetLocks() *TargetLocks {
+	return &TargetLocks{
+		locks: make(map[string]*targetLock),
+	}
+}
+
+// acquire blocks until the target's lock is held, returning the function that
+// releases it.
+func (tl *TargetLocks) acquire(target string) func() {
+	tl.lock.Lock()
+	lock, ok := tl.locks[target]
+	if !ok {
+		lock = &targetLock{}
+		tl.locks[target] = lock
+	}
+	lock.users++
+	tl.lock.Unlock()
+	lock.mutex.Lock()
+	return func() {
+		lock.mutex.Unlock()
+		tl.lock.Lock()
+		defer tl.lock.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(tl.locks, target)
+		}
+	}
+}
+
+// ExclusiveMiddleware ensures that only one process is running at any one time
+// for a given target key (e.g. an SSH address or container ID), across all
+// executors wrapped with this middleware using the same set of locks and key.
+// Executing a cmd will block until the target is free. The target is freed
+// once a result is obtained from the process, or the process is closed. If the
+// locks are nil, the middleware uses a set of its own.
+func ExclusiveMiddleware(locks *TargetLocks, target string) Middleware {
+	if locks == nil {
+		locks = NewTargetLocks()
+	}
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			return holdUntilDone(next, c, locks.acquire(target))
+		}
+	}
+}
+
+// holdUntilDone executes the cmd, calling release once the process is done
+// with (or immediately if execution fails).
+func holdUntilDone(next ExecFunc, c Cmd, release func()) (Process, error) {
+	process, err := next(c)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return withDoneHook(process, release), nil
+}
+
+// withDoneHook wraps the process such that done is called exactly once, when
+// either a result is obtained from the process or the process is closed.
+func withDoneHook(process Process, done func()) Process {
+	once := sync.Once{}
+	hook := func() { once.Do(done) }
+	return &hookedProcess{
+		Process: process,
+		onResult: func(r *Result, err error) (*Result, error) {
+			hook()
+			return r, err
+		},
+		onClose: hook,
+	}
+}
+
+// withRedaction wraps the process such that the secrets are masked in its
+// result and any error obtaining the result.
+func withRedaction(process Process, secrets []string) Process {
+	return &hookedProcess{
+		Process: process,
+		onResult: func(r *Result, err error) (*Result, error) {
+			if r != nil {
+				r.StdOut = redact(r.StdOut, secrets)
+				r.StdErr = redact(r.StdErr, secrets)
+				for idx := range r.Attempts {
+					r.Attempts[idx].StdOut = redact(r.Attempts[idx].StdOut, secrets)
+					r.Attempts[idx].StdErr = redact(r.Attempts[idx].StdErr, secrets)
+				}
+			}
+			return r, redactError(err, secrets)
+		},
+	}
+}
+
+// hookedProcess wraps a process, allowing for the result to be modified and
+// for actions to be taken when the process is killed or closed.
+type hookedProcess struct {
+	Process
+	onResult func(*Result, error) (*Result, error)
+	onKill   func()
+	onClose  func()
+}
+
+func (p *hookedProcess) Kill() error {
+	err := p.Process.Kill()
+	if p.onKill != nil {
+		p.onKill()
+	}
+	return err
+}
+
+func (p *hookedProcess) Result() (*Result, error) {
+	result, err := p.Process.Result()
+	if p.onResult != nil {
+		return p.onResult(result, err)
+	}
+	return result, err
+}
+
+func (p *hookedProcess) Close() {
+	p.Process.Close()
+	if p.onClose != nil {
+		p.onClose()
+	}
+}
+
+// redact replaces all occurrences of the given secrets in s with a mask.
+func redact(s string, secrets []string) string {
+	ordered := append([]string{}, secrets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(ordered[i]) > len(ordered[j])
+	})
+	for _, secret := range ordered {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactionMask)
+		}
+	}
+	return s
+}
+
+// redactError returns an error with the same message as the given error, but
+// with any secrets masked.
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+	message := redact(err.Error(), secrets)
+	if message == err.Error() {
+		return err
+	}
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,209 @@
+package nescript
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedactionMiddlewareMasksCmdSecrets(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	executor := RedactionMiddleware("extra")(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		return &fakeProcess{
+			result: &Result{StdOut: "s3cr3t 'it'\\''s' extra", Attempts: []Result{{StdErr: "s3cr3t"}}},
+			err:    errors.New("failed with s3cr3t"),
+		}, nil
+	}))
+	cmd := NewCmd("echo").WithSecretField("a", "s3cr3t").WithSecretField("b", "it's")
+	process, err := executor(cmd)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if err == nil || err.Error() != "failed with ****" {
+		t.Errorf("error was not masked: %v", err)
+	}
+	if result == nil {
+		t.Fatal("result was dropped along with the error")
+	}
+	if result.StdOut != "**** **** ****" || result.Attempts[0].StdErr != "****" {
+		t.Errorf("result was not masked: %+v", result)
+	}
+}
+
+func TestRedactionMiddlewareWithoutSecrets(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	fake := &fakeProcess{result: &Result{StdOut: "out"}}
+	executor := RedactionMiddleware()(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		return fake, nil
+	}))
+	process, err := executor(*NewCmd("echo"))
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	if process != Process(fake) {
+		t.Error("process of a cmd without secrets was wrapped")
+	}
+}
+
+func TestExecLeavesRedactionToExecutor(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	fake := &fakeProcess{result: &Result{StdOut: "s3cr3t"}}
+	executor := fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		return fake, nil
+	})
+	process, err := NewCmd("echo").WithSecretField("token", "s3cr3t").Exec(executor)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	if process != Process(fake) {
+		t.Error("process was wrapped by Exec rather than the executor")
+	}
+}
+
+// blockingProcess is a process whose result is not available until it is
+// released.
+type blockingProcess struct {
+	fakeProcess
+	release chan struct{}
+}
+
+func (p *blockingProcess) Result() (*Result, error) {
+	<-p.release
+	return p.fakeProcess.Result()
+}
+
+func TestChainOrder(t *testing.T) {
+	order := make([]string, 0)
+	named := func(name string) Middleware {
+		return func(next ExecFunc) ExecFunc {
+			return func(c Cmd) (Process, error) {
+				order = append(order, name+" before")
+				process, err := next(c)
+				order = append(order, name+" after")
+				return process, err
+			}
+		}
+	}
+	cmds := make([]Cmd, 0)
+	executor := fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		order = append(order, "executor")
+		return &fakeProcess{result: &Result{}}, nil
+	}).With(named("outer"), nil, named("inner"))
+	if _, err := executor(*NewCmd("true")); err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	expected := []string{"outer before", "inner before", "executor", "inner after", "outer after"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("middleware ran in order %v", order)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var output bytes.Buffer
+	cmds := make([]Cmd, 0)
+	executor := LoggingMiddleware(log.New(&output, "", 0))(fakeExecutor(&cmds, func(c Cmd) (Process, error) {
+		if len(cmds) > 1 {
+			return nil, errors.New("unreachable")
+		}
+		return &fakeProcess{result: &Result{ExitCode: 3}}, nil
+	}))
+	cmd := NewCmd("echo", "{{.token}}").WithSecretField("token", "s3cr3t").MustCompile()
+	process, err := executor(cmd)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	process.Result()
+	executor(cmd)
+	expected := "executing: echo ****\ncompleted with exit code 3: echo ****\n" +
+		"executing: echo ****\nfailed to execute: echo ****: unreachable\n"
+	if output.String() != expected {
+		t.Errorf("unexpected log:\n%s", output.String())
+	}
+}
+
+func TestTimingMiddleware(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	executor := TimingMiddleware()(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		return &fakeProcess{result: &Result{}}, nil
+	}))
+	process, err := executor(*NewCmd("true"))
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	result, _ := process.Result()
+	if result.TotalTime < 20*time.Millisecond {
+		t.Errorf("total time %s does not include the time until the result", result.TotalTime)
+	}
+}
+
+// maxRunning executes n cmds concurrently via the middleware, returning the
+// maximum number of processes that were running at once.
+func maxRunning(t *testing.T, n int, middleware func(idx int) Middleware) int {
+	t.Helper()
+	var lock sync.Mutex
+	running, max := 0, 0
+	executor := ExecFunc(func(Cmd) (Process, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if running++; running > max {
+			max = running
+		}
+		release := make(chan struct{})
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			close(release)
+		}()
+		return &blockingProcess{fakeProcess: fakeProcess{result: &Result{}}, release: release}, nil
+	})
+	var group sync.WaitGroup
+	for idx := 0; idx < n; idx++ {
+		group.Add(1)
+		go func(idx int) {
+			defer group.Done()
+			process, err := executor.With(middleware(idx))(*NewCmd("true"))
+			if err != nil {
+				t.Errorf("failed to exec: %v", err)
+				return
+			}
+			process.Result()
+		}(idx)
+	}
+	group.Wait()
+	return max
+}
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	limit := ConcurrencyLimitMiddleware(2)
+	if max := maxRunning(t, 6, func(int) Middleware { return limit }); max != 2 {
+		t.Errorf("%d processes ran at once, expected 2", max)
+	}
+}
+
+func TestExclusiveMiddleware(t *testing.T) {
+	locks := NewTargetLocks()
+	same := func(int) Middleware { return ExclusiveMiddleware(locks, "host") }
+	if max := maxRunning(t, 4, same); max != 1 {
+		t.Errorf("%d processes ran at once on the same target", max)
+	}
+	perTarget := func(idx int) Middleware { return ExclusiveMiddleware(locks, fmt.Sprint(idx%2)) }
+	if max := maxRunning(t, 4, perTarget); max != 2 {
+		t.Errorf("%d processes ran at once on 2 targets", max)
+	}
+	separate := func(int) Middleware { return ExclusiveMiddleware(NewTargetLocks(), "host") }
+	if max := maxRunning(t, 3, separate); max != 3 {
+		t.Errorf("%d processes ran at once with separate locks", max)
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks are held after every process is done", len(locks.locks))
+	}
+}
@@ -0,0 +1,231 @@
+package nescript
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PipelineExecFunc is used to execute a pipeline, in-turn creating a single
+// process that represents every stage of the pipeline. Writing to the process
+// writes to the stdin of the first stage, and the result holds the stdout of
+// the last stage, the stderr of every stage, and the exit code of each stage.
+type PipelineExecFunc func(Pipeline) (Process, error)
+
+// Pipeline is a sequence of cmds where the stdout of each cmd is connected to
+// the stdin of the next, as in `cmd1 | cmd2` in a shell. Each cmd keeps its own
+// args, env vars and template data. As with a shell pipeline, the exit code of
+// the pipeline is that of the last stage, whilst the exit code of every stage
+// is given in the result's PipeStatus.
+type Pipeline struct {
+	stages []Cmd
+}
+
+const (
+	// pipeStatusMarker prefixes the line written to stderr by a generated shell
+	// pipeline that reports the exit code of each stage.
+	pipeStatusMarker string = "::nescript-pipestatus::"
+)
+
+var (
+	// pipeStatusLineRegex matches the line that reports the exit code of each
+	// stage of a generated shell pipeline.
+	pipeStatusLineRegex *regexp.Regexp = regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(pipeStatusMarker) + `([0-9 -]*)\n?`)
+)
+
+// NewPipeline creates a pipeline from the given cmds, where the first cmd is
+// the first stage of the pipeline.
+func NewPipeline(cmds ...Cmd) *Pipeline {
+	pipeline := Pipeline{
+		stages: append([]Cmd{}, cmds...),
+	}
+	return &pipeline
+}
+
+// Stages returns the cmds that make up the pipeline, in order.
+func (p Pipeline) Stages() []Cmd {
+	return append([]Cmd{}, p.stages...)
+}
+
+// WithStage adds a cmd to the end of the pipeline.
+func (p Pipeline) WithStage(cmd Cmd) Pipeline {
+	p.stages = append(p.Stages(), cmd)
+	return p
+}
+
+// Compile compiles every stage of the pipeline (see Cmd.Compile). This errors
+// if any stage fails to compile.
+func (p Pipeline) Compile() (Pipeline, error) {
+	compiled := make([]Cmd, len(p.stages))
+	for idx, stage := range p.stages {
+		compiledStage, err := stage.Compile()
+		if err != nil {
+			return p, fmt.Errorf("failed to compile pipeline stage %d: %w", idx, err)
+		}
+		compiled[idx] = compiledStage
+	}
+	p.stages = compiled
+	return p, nil
+}
+
+// MustCompile compiles the pipeline, however will panic if an error occurs.
+func (p Pipeline) MustCompile() Pipeline {
+	compiledPipeline, err := p.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return compiledPipeline
+}
+
+// Secrets returns the secret values of every stage of the pipeline.
+func (p Pipeline) Secrets() []string {
+	secrets := make([]string, 0)
+	for _, stage := range p.stages {
+		secrets = append(secrets, stage.Secrets()...)
+	}
+	return secrets
+}
+
+// String returns every stage of the pipeline formatted by its formatter (see
+// Cmd.String), joined with " | ". Secret values are masked.
+func (p Pipeline) String() string {
+	formatted := make([]string, len(p.stages))
+	for idx, stage := range p.stages {
+		formatted[idx] = stage.String()
+	}
+	return strings.Join(formatted, " | ")
+}
+
+// Cmd converts the pipeline into a single cmd that runs the pipeline via a
+// POSIX shell (["sh", "-c", script]), so that it can be executed by any
+// executor. Every arg of each stage is quoted (see ShellQuoteFormatter), and
+// the env vars of each stage are set for that stage only (as assignments
+// before the stage's command), on top of the env of the shell. Secret env vars
+// are not written into the script, instead they are env vars of the cmd
+// (under generated names) that the script moves into the stage's env, so
+// their values are never part of the cmd's args. The exit code of each stage
+// is reported on a line of stderr, which is parsed and removed by
+// ShellPipelineExecutor. If the exit code of a stage is unknown (e.g. the
+// shell running it was killed), it is reported as -1, and the cmd exits with
+// 125 if this is the last stage. The secrets of every stage are secrets of the cmd.
+// This errors if the pipeline has no stages, or a stage has an env var whose
+// key is not a valid shell variable name.
+func (p Pipeline) Cmd() (Cmd, error) {
+	if len(p.stages) == 0 {
+		return Cmd{}, fmt.Errorf("pipeline has no stages")
+	}
+	quote := ShellQuoteFormatter()
+	setup := []string{`nescript_dir=$(mktemp -d) || exit 125`}
+	secretEnv := make([]string, 0)
+	stages := make([]string, len(p.stages))
+	statusFiles := make([]string, len(p.stages))
+	for idx, stage := range p.stages {
+		assignments := make([]string, 0)
+		for _, e := range stage.Env() {
+			if !envAssignmentRegex.MatchString(e) {
+				return Cmd{}, fmt.Errorf("env var of pipeline stage %d is not a valid shell assignment", idx)
+			}
+			key, value, _ := strings.Cut(e, "=")
+			if stage.Redact(value) == value {
+				assignments = append(assignments, key+"="+ShellQuote(value))
+				continue
+			}
+			name := fmt.Sprintf("nescript_secret_%d", len(secretEnv))
+			secretEnv = append(secretEnv, "NESCRIPT_SECRET_"+strconv.Itoa(len(secretEnv))+"="+value)
+			setup = append(setup, fmt.Sprintf(`%s=$NESCRIPT_SECRET_%d; unset NESCRIPT_SECRET_%d`, name, len(secretEnv)-1, len(secretEnv)-1))
+			assignments = append(assignments, fmt.Sprintf(`%s="$%s"`, key, name))
+		}
+		command := quote(stage.Raw())
+		if len(assignments) > 0 {
+			command = strings.Join(assignments, " ") + " " + command
+		}
+		statusFiles[idx] = fmt.Sprintf(`"$nescript_dir/%d"`, idx)
+		stages[idx] = fmt.Sprintf("{ %s; echo $? >%s; }", command, statusFiles[idx])
+	}
+	script := strings.Join(append(setup,
+		strings.Join(stages, " | "),
+		`nescript_status=`,
+		fmt.Sprintf(`for nescript_file in %s; do`, strings.Join(statusFiles, " ")),
+		`nescript_code=$(cat "$nescript_file" 2>/dev/null)`,
+		`nescript_status="$nescript_status ${nescript_code:--1}"`,
+		`done`,
+		`rm -rf "$nescript_dir"`,
+		fmt.Sprintf(`printf '%s%%s\n' "$nescript_status" >&2`, pipeStatusMarker),
+		`exit "${nescript_code:-125}"`,
+	), "\n")
+	cmd := NewCmd(SCShell[0], SCShell[1], script)
+	cmd.addEnv(secretEnv...)
+	cmd.addSecrets(p.Secrets()...)
+	return *cmd, nil
+}
+
+// Exec calls the given PipelineExecFunc to execute the pipeline. Returned will
+// be the process that is created as a result of execution. If any stage has
+// secret values, they are masked in the output of the process and any errors.
+func (p Pipeline) Exec(executor PipelineExecFunc) (Process, error) {
+	secrets := p.Secrets()
+	process, err := executor(p)
+	if err != nil {
+		return nil, redactError(err, secrets)
+	}
+	if len(secrets) > 0 {
+		return withRedaction(process, secrets), nil
+	}
+	return process, nil
+}
+
+// CompileExec compiles every stage of the pipeline, then calls the given
+// PipelineExecFunc to execute it.
+func (p Pipeline) CompileExec(executor PipelineExecFunc) (Process, error) {
+	compiled, err := p.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return compiled.Exec(executor)
+}
+
+// ShellPipelineExecutor returns a PipelineExecFunc that executes pipelines via
+// the given ExecFunc, by converting them to a shell pipeline (see
+// Pipeline.Cmd). Thus this works with any executor whose target has a POSIX
+// shell, such as SSH and docker targets. The exit code of each stage is parsed
+// from stderr and set as the result's PipeStatus.
+func ShellPipelineExecutor(executor ExecFunc) PipelineExecFunc {
+	return func(p Pipeline) (Process, error) {
+		cmd, err := p.Cmd()
+		if err != nil {
+			return nil, err
+		}
+		process, err := executor(cmd)
+		if err != nil {
+			return nil, err
+		}
+		return &hookedProcess{
+			Process: process,
+			onResult: func(r *Result, err error) (*Result, error) {
+				if err != nil {
+					return r, err
+				}
+				r.PipeStatus, r.StdErr = parsePipeStatus(r.StdErr)
+				return r, nil
+			},
+		}, nil
+	}
+}
+
+// parsePipeStatus extracts the last pipe status line from stderr, returning
+// the exit code of each stage and stderr with the line removed. If there is no
+// such line (e.g. the shell was killed), the exit codes are nil.
+func parsePipeStatus(stderr string) ([]int, string) {
+	locations := pipeStatusLineRegex.FindAllStringSubmatchIndex(stderr, -1)
+	if len(locations) == 0 {
+		return nil, stderr
+	}
+	location := locations[len(locations)-1]
+	statuses := make([]int, 0)
+	for _, field := range strings.Fields(stderr[location[2]:location[3]]) {
+		status, _ := strconv.Atoi(field)
+		statuses = append(statuses, status)
+	}
+	return statuses, stderr[:location[0]] + stderr[location[1]:]
+}
@@ -1,6 +1,10 @@
 package nescript
 
-import "os"
+import (
+	"context"
+	"fmt"
+	"os"
+)
 
 // Process is a single instance of the script, either running or exited. A
 // process can be used to control the script and extract results from a script
@@ -29,3 +33,28 @@ type Process interface {
 	// appropriate.
 	Close()
 }
+
+// WaitContext waits for the result of the process, as Result does. However,
+// if the context is cancelled (or its deadline is reached) first, the process
+// is killed, and an error is returned once it has exited.
+func WaitContext(ctx context.Context, process Process) (*Result, error) {
+	var (
+		result *Result
+		err    error
+	)
+	done := make(chan struct{})
+	go func() {
+		result, err = process.Result()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return result, err
+	case <-ctx.Done():
+		if killErr := process.Kill(); killErr != nil {
+			return nil, fmt.Errorf("failed to kill process: %w", killErr)
+		}
+		<-done
+		return nil, fmt.Errorf("process was killed: %w", ctx.Err())
+	}
+}
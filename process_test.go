@@ -0,0 +1,25 @@
+package nescript
+
+import "os"
+
+// fakeProcess is a process that has already completed with the given result
+// and error.
+type fakeProcess struct {
+	result *Result
+	err    error
+}
+
+func (p *fakeProcess) Kill() error              { return nil }
+func (p *fakeProcess) Signal(os.Signal) error   { return nil }
+func (p *fakeProcess) Write(string) error       { return nil }
+func (p *fakeProcess) Result() (*Result, error) { return p.result, p.err }
+func (p *fakeProcess) Close()                   {}
+
+// fakeExecutor returns an ExecFunc that records the cmds it is given, and
+// returns the processes created by the given function.
+func fakeExecutor(cmds *[]Cmd, process func(c Cmd) (Process, error)) ExecFunc {
+	return func(c Cmd) (Process, error) {
+		*cmds = append(*cmds, c)
+		return process(c)
+	}
+}
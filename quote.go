@@ -0,0 +1,484 @@
+package nescript
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellContext is the quoting context of a position within a POSIX shell
+// script.
+type shellContext int
+
+const (
+	shellBare shellContext = iota
+	shellSingleQuoted
+	shellDoubleQuoted
+	shellANSIQuoted
+	shellComment
+	shellHeredoc
+	shellQuotedHeredoc
+)
+
+// heredoc is a here-document (<<WORD) whose body is yet to be, or is being,
+// scanned.
+type heredoc struct {
+	delimiter string
+	stripTabs bool
+	quoted    bool
+}
+
+// shellFrame is the scanning state of a script, or of a command
+// substitution, parameter expansion or backquoted command nested within it.
+type shellFrame struct {
+	context shellContext
+
+	// closer is the byte that ends the frame, or 0 for the script itself.
+	closer byte
+	depth  int
+
+	// word is true if the previous byte is part of a word, such that a # does
+	// not start a comment.
+	word bool
+
+	// dollar is true if the previous byte is an unescaped $, and escaped is
+	// true if the next byte is escaped by a backslash.
+	dollar  bool
+	escaped bool
+
+	// pending heredocs have bodies that start at the next newline, whilst the
+	// body of heredoc is being scanned, where line is the content of the
+	// current line of the body since its start or the last template action,
+	// and action is true if the line contains a template action.
+	pending []heredoc
+	heredoc heredoc
+	line    string
+	action  bool
+}
+
+// shellState is the state of a POSIX shell script at some position, as found
+// by scanning the script's text up to that position.
+type shellState struct {
+	frames []shellFrame
+
+	// ambiguous is the reason the state of the script is not known at the
+	// position, if it is not (until the next newline).
+	ambiguous string
+}
+
+// ShellQuote wraps the string in single quotes, escaping any single quotes
+// within it, such that a POSIX shell treats it as a single literal word.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellEscapeSingleQuoted escapes the string so that it is treated literally
+// when placed within an existing single quoted string.
+func shellEscapeSingleQuoted(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// shellEscapeDoubleQuoted escapes the string so that it is treated literally
+// when placed within an existing double quoted string.
+func shellEscapeDoubleQuoted(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return replacer.Replace(s)
+}
+
+// shellEscapeANSIQuoted escapes the string so that it is treated literally
+// when placed within an existing $'...' string.
+func shellEscapeANSIQuoted(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return replacer.Replace(s)
+}
+
+// shellEscapeHeredoc escapes the string so that it is treated literally when
+// placed within the body of a heredoc with an unquoted delimiter.
+func shellEscapeHeredoc(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "$", `\$`, "`", "\\`")
+	return replacer.Replace(s)
+}
+
+// escaper returns the function that escapes a value for the given context,
+// other than a heredoc context (see heredoc.escaper).
+func (sc shellContext) escaper() any {
+	if sc == shellComment {
+		return func(args ...any) (string, error) {
+			value := fmt.Sprint(args...)
+			if strings.Contains(value, "\n") {
+				return "", fmt.Errorf("a value containing a newline can not be inserted into a comment")
+			}
+			return value, nil
+		}
+	}
+	var escape func(string) string
+	switch sc {
+	case shellSingleQuoted:
+		escape = shellEscapeSingleQuoted
+	case shellDoubleQuoted:
+		escape = shellEscapeDoubleQuoted
+	case shellANSIQuoted:
+		escape = shellEscapeANSIQuoted
+	default:
+		escape = ShellQuote
+	}
+	return func(args ...any) string {
+		return escape(fmt.Sprint(args...))
+	}
+}
+
+// heredocLine is the text around a value inserted into a line of a heredoc
+// body, which is used to determine if the value could end the heredoc.
+type heredocLine struct {
+	// prefix is the text on the line before the value, back to the start of
+	// the line or the previous action, where prefixKnown is true if it is
+	// back to the start of the line. Likewise, suffix is the text after the
+	// value, up to the end of the line or the next action.
+	prefix      string
+	prefixKnown bool
+	suffix      string
+	suffixKnown bool
+}
+
+// escaper returns the function that escapes a value inserted into the body
+// of the heredoc at the given position. This errors if a line of the value,
+// along with the text around it, is the delimiter. Where another action is on
+// the same line, the value errors if its line could form the delimiter along
+// with the other action.
+func (doc heredoc) escaper(line heredocLine) any {
+	return func(args ...any) (string, error) {
+		value := fmt.Sprint(args...)
+		if !doc.quoted {
+			value = shellEscapeHeredoc(value)
+		}
+		if doc.couldEnd(value, line) {
+			return "", fmt.Errorf("value could end the heredoc delimited by '%s'", doc.delimiter)
+		}
+		return value, nil
+	}
+}
+
+// couldEnd determines if the (escaped) value at the given position could form
+// a line that is the delimiter.
+func (doc heredoc) couldEnd(value string, line heredocLine) bool {
+	lines := strings.Split(value, "\n")
+	for idx, text := range lines {
+		startKnown, endKnown := true, true
+		if idx == 0 {
+			text = line.prefix + text
+			startKnown = line.prefixKnown
+		}
+		if idx == len(lines)-1 {
+			text += line.suffix
+			endKnown = line.suffixKnown
+		}
+		if doc.stripTabs {
+			text = strings.TrimLeft(text, "\t")
+		}
+		var couldEnd bool
+		switch {
+		case startKnown && endKnown:
+			couldEnd = text == doc.delimiter
+		case text == "":
+			// the line is made up of only the other actions on it
+		case startKnown:
+			couldEnd = strings.HasPrefix(doc.delimiter, text)
+		case endKnown:
+			couldEnd = strings.HasSuffix(doc.delimiter, text)
+		default:
+			couldEnd = strings.Contains(doc.delimiter, text)
+		}
+		if couldEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// newShellState returns the state at the start of a script.
+func newShellState() *shellState {
+	return &shellState{
+		frames: []shellFrame{{context: shellBare}},
+	}
+}
+
+// clone returns a copy of the state that can be scanned independently.
+func (ss *shellState) clone() *shellState {
+	cloned := &shellState{
+		frames:    make([]shellFrame, len(ss.frames)),
+		ambiguous: ss.ambiguous,
+	}
+	copy(cloned.frames, ss.frames)
+	for idx := range cloned.frames {
+		cloned.frames[idx].pending = append([]heredoc{}, cloned.frames[idx].pending...)
+	}
+	return cloned
+}
+
+// frame returns the innermost frame.
+func (ss *shellState) frame() *shellFrame {
+	return &ss.frames[len(ss.frames)-1]
+}
+
+// push starts a nested frame that is ended by the given byte.
+func (ss *shellState) push(closer byte) {
+	ss.frames = append(ss.frames, shellFrame{
+		context: shellBare,
+		closer:  closer,
+	})
+}
+
+// pop ends the innermost frame, returning to the enclosing frame.
+func (ss *shellState) pop() {
+	ss.frames = ss.frames[:len(ss.frames)-1]
+	ss.frame().word = true
+}
+
+// context returns the context that a template action at the current position
+// is in, along with the heredoc (if in a heredoc context). This errors if the
+// action could not be escaped reliably.
+func (ss *shellState) context() (shellContext, heredoc, error) {
+	frame := ss.frame()
+	switch {
+	case ss.ambiguous != "":
+		return 0, heredoc{}, fmt.Errorf("it is within %s", ss.ambiguous)
+	case frame.escaped:
+		return 0, heredoc{}, fmt.Errorf("it follows a backslash")
+	case frame.dollar:
+		return 0, heredoc{}, fmt.Errorf("it follows a $")
+	case frame.closer == '}':
+		return 0, heredoc{}, fmt.Errorf("it is within a parameter expansion")
+	}
+	for _, f := range ss.frames {
+		if f.closer == '`' {
+			return 0, heredoc{}, fmt.Errorf("it is within a backquoted command")
+		}
+	}
+	return frame.context, frame.heredoc, nil
+}
+
+// action updates the state for a template action at the current position.
+func (ss *shellState) action() {
+	ss.included()
+	ss.frame().word = true
+}
+
+// included updates the state for a template included at the current position.
+func (ss *shellState) included() {
+	frame := ss.frame()
+	frame.line = ""
+	frame.action = true
+}
+
+// heredocLine returns the position of an action within the current line of a
+// heredoc body, given the text that follows the action (and whether the text
+// ends the line).
+func (ss *shellState) heredocLine(suffix string, suffixKnown bool) heredocLine {
+	frame := ss.frame()
+	return heredocLine{
+		prefix:      frame.line,
+		prefixKnown: !frame.action,
+		suffix:      suffix,
+		suffixKnown: suffixKnown,
+	}
+}
+
+// key returns a string that is equal for equal states.
+func (ss *shellState) key() string {
+	return fmt.Sprintf("%v", *ss)
+}
+
+// scan updates the state for the given shell text.
+func (ss *shellState) scan(text string) {
+	for idx := 0; idx < len(text); idx++ {
+		frame := ss.frame()
+		c := text[idx]
+		dollar := frame.dollar
+		frame.dollar = false
+		if c == '\n' {
+			ss.ambiguous = ""
+		}
+		if frame.escaped {
+			frame.escaped = false
+			frame.word = true
+			if frame.context == shellHeredoc && c != '\n' {
+				frame.line += string(c)
+			}
+			continue
+		}
+		switch frame.context {
+		case shellBare:
+			switch {
+			case frame.closer == '`' && c == '`':
+				ss.pop()
+			case c == '(' && dollar:
+				ss.push(')')
+			case frame.closer == ')' && c == '(':
+				frame.depth++
+			case frame.closer == '}' && c == '{':
+				frame.depth++
+			case frame.closer != 0 && frame.closer != '`' && c == frame.closer:
+				if frame.depth == 0 {
+					ss.pop()
+				} else {
+					frame.depth--
+				}
+			case c == '\'':
+				frame.word = true
+				frame.context = shellSingleQuoted
+				if dollar {
+					frame.context = shellANSIQuoted
+				}
+			case c == '"':
+				frame.word = true
+				frame.context = shellDoubleQuoted
+			case c == '#' && !frame.word:
+				frame.context = shellComment
+			case c == '\n':
+				frame.word = false
+				ss.startHeredoc()
+			case c == '<' && strings.HasPrefix(text[idx:], "<<<"):
+				idx += 2
+				frame.word = false
+			case c == '<' && strings.HasPrefix(text[idx:], "<<"):
+				idx = ss.scanHeredocWord(text, idx+2) - 1
+				frame.word = false
+			case c == ' ' || c == '\t' || strings.IndexByte(";&|()<>", c) >= 0:
+				frame.word = false
+			default:
+				frame.word = true
+				ss.scanQuoting(c, dollar)
+			}
+		case shellSingleQuoted:
+			if c == '\'' {
+				frame.context = shellBare
+			}
+		case shellANSIQuoted:
+			switch c {
+			case '\\':
+				frame.escaped = true
+			case '\'':
+				frame.context = shellBare
+			}
+		case shellDoubleQuoted:
+			if c == '"' {
+				frame.context = shellBare
+			} else {
+				ss.scanQuoting(c, dollar)
+			}
+		case shellComment:
+			if c == '\n' {
+				frame.context = shellBare
+				frame.word = false
+				ss.startHeredoc()
+			}
+		case shellHeredoc, shellQuotedHeredoc:
+			if c == '\n' {
+				ss.endHeredocLine()
+				continue
+			}
+			frame.line += string(c)
+			if frame.context == shellHeredoc {
+				ss.scanQuoting(c, dollar)
+			}
+		}
+	}
+}
+
+// scanQuoting handles the bytes that escape, or start expansions, within
+// unquoted and double quoted text, and heredoc bodies with an unquoted
+// delimiter.
+func (ss *shellState) scanQuoting(c byte, dollar bool) {
+	frame := ss.frame()
+	switch {
+	case c == '\\':
+		frame.escaped = true
+	case c == '$':
+		frame.dollar = true
+	case c == '`':
+		ss.push('`')
+	case c == '(' && dollar:
+		ss.push(')')
+	case c == '{' && dollar:
+		ss.push('}')
+	}
+}
+
+// scanHeredocWord reads the delimiter of a heredoc operator, starting just
+// after the <<, adding the heredoc as pending. The index after the delimiter
+// is returned.
+func (ss *shellState) scanHeredocWord(text string, idx int) int {
+	doc := heredoc{}
+	if idx < len(text) && text[idx] == '-' {
+		doc.stripTabs = true
+		idx++
+	}
+	for idx < len(text) && (text[idx] == ' ' || text[idx] == '\t') {
+		idx++
+	}
+	var delimiter strings.Builder
+	complete := false
+	for !complete && idx < len(text) {
+		switch c := text[idx]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(text[idx+1:], c)
+			if end < 0 {
+				idx = len(text)
+				continue
+			}
+			delimiter.WriteString(text[idx+1 : idx+1+end])
+			doc.quoted = true
+			idx += end + 2
+		case c == '\\' && idx+1 < len(text):
+			delimiter.WriteByte(text[idx+1])
+			doc.quoted = true
+			idx += 2
+		case c == ' ' || c == '\t' || c == '\n' || strings.IndexByte(";&|()<>", c) >= 0:
+			complete = true
+		default:
+			delimiter.WriteByte(c)
+			idx++
+		}
+	}
+	if !complete || delimiter.Len() == 0 {
+		ss.ambiguous = "a heredoc delimiter"
+	}
+	doc.delimiter = delimiter.String()
+	frame := ss.frame()
+	frame.pending = append(frame.pending, doc)
+	return idx
+}
+
+// startHeredoc starts the body of the next pending heredoc (if any) of the
+// innermost frame.
+func (ss *shellState) startHeredoc() {
+	frame := ss.frame()
+	if len(frame.pending) == 0 {
+		return
+	}
+	frame.heredoc = frame.pending[0]
+	frame.pending = frame.pending[1:]
+	frame.line = ""
+	frame.action = false
+	frame.context = shellHeredoc
+	if frame.heredoc.quoted {
+		frame.context = shellQuotedHeredoc
+	}
+}
+
+// endHeredocLine handles the end of a line of a heredoc body, ending the body
+// if the line is the delimiter.
+func (ss *shellState) endHeredocLine() {
+	frame := ss.frame()
+	line := frame.line
+	if frame.heredoc.stripTabs {
+		line = strings.TrimLeft(line, "\t")
+	}
+	isDelimiter := !frame.action && line == frame.heredoc.delimiter
+	frame.line = ""
+	frame.action = false
+	if isDelimiter {
+		frame.context = shellBare
+		frame.word = false
+		ss.startHeredoc()
+	}
+}
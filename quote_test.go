@@ -0,0 +1,190 @@
+package nescript
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runShell executes the script with the given shell, returning its stdout.
+func runShell(t *testing.T, shell, script string) string {
+	t.Helper()
+	if _, err := exec.LookPath(shell); err != nil {
+		t.Skipf("%s is not available", shell)
+	}
+	output, err := exec.Command(shell, "-c", script).Output()
+	if err != nil {
+		t.Fatalf("script failed: %v\n%s", err, script)
+	}
+	return string(output)
+}
+
+func TestShellEscapingContexts(t *testing.T) {
+	tests := []struct {
+		name   string
+		shell  string
+		script string
+	}{
+		{"bare", "sh", "echo {{.x}}"},
+		{"single quoted", "sh", "echo 'v={{.x}}'"},
+		{"double quoted", "sh", `echo "v={{.x}}"`},
+		{"comment with apostrophe", "sh", "# don't touch\necho {{.x}}"},
+		{"trailing comment with apostrophe", "sh", "echo {{.x}} # it's\necho {{.x}}"},
+		{"hash within word", "sh", "echo a#'{{.x}}'"},
+		{"heredoc with apostrophe", "sh", "cat <<EOF\nit's {{.x}}\nEOF\necho {{.x}}"},
+		{"quoted heredoc with apostrophe", "sh", "cat <<'EOF'\nit's {{.x}}\nEOF\necho {{.x}}"},
+		{"heredoc stripping tabs", "sh", "cat <<-EOF\n\tit's {{.x}}\n\tEOF\necho {{.x}}"},
+		{"heredoc followed by command", "sh", "cat <<EOF; echo {{.x}}\nit's\nEOF\necho {{.x}}"},
+		{"here-string", "bash", "cat <<<it\\'s; echo {{.x}}"},
+		{"ansi quoted with apostrophe", "bash", "echo $'it\\'s' {{.x}}"},
+		{"ansi quoted", "bash", "echo $'v={{.x}}'"},
+		{"command substitution with apostrophe", "sh", `echo $(printf '%s' "it's") {{.x}}`},
+		{"command substitution in double quotes", "sh", `echo "$(echo "{{.x}}")"`},
+		{"command substitution with parentheses", "sh", "echo $( (echo \"it's\") ) {{.x}}"},
+		{"arithmetic", "sh", "echo $((1 + 2)) {{.x}}"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pwned := filepath.Join(t.TempDir(), "pwned")
+			value := `a'; touch ` + pwned + ` #"$(touch ` + pwned + `)` + "`touch " + pwned + "`\\"
+			script, err := NewScript(test.script).WithField("x", value).WithEscaping(ShellEscaping).Compile()
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			output := runShell(t, test.shell, script.Raw())
+			if _, err := os.Stat(pwned); err == nil {
+				t.Fatalf("value was executed by the script:\n%s", script.Raw())
+			}
+			if !strings.Contains(output, value) {
+				t.Errorf("output %q does not contain the value, script:\n%s", output, script.Raw())
+			}
+		})
+	}
+}
+
+func TestShellEscapingRejectsBreakout(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		value  string
+	}{
+		{"newline in comment", "# {{.x}}\necho hi", "a\ntouch pwned"},
+		{"heredoc delimiter", "cat <<EOF\n{{.x}}\nEOF", "a\nEOF\ntouch pwned"},
+		{"partial heredoc delimiter", "cat <<EOF\n{{.x}}F\nEOF", "EO"},
+		{"quoted heredoc delimiter", "cat <<'EOF'\n{{.x}}\nEOF", "EOF"},
+		{"tab stripped heredoc delimiter", "cat <<-EOF\n{{.x}}\nEOF", "a\n\tEOF"},
+		{"heredoc delimiter after text", "cat <<EOF\nE{{.x}}\nEOF", "OF"},
+		{"heredoc delimiter across actions", "cat <<EE\n{{.x}}{{.x}}\nEE", "E"},
+		{"heredoc delimiter in included template", "cat <<EOF\n{{template \"t\" .}}F\nEOF{{define \"t\"}}{{.x}}{{end}}", "EO"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := NewScript(test.script).WithField("x", test.value).WithEscaping(ShellEscaping)
+			if compiled, err := script.Compile(); err == nil {
+				t.Errorf("expected compilation to fail, got:\n%s", compiled.Raw())
+			}
+		})
+	}
+}
+
+func TestShellEscapingHeredocValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		value  string
+	}{
+		{"prefix of delimiter", "cat <<EOF\n{{.x}}\nEOF", "EO"},
+		{"suffix of delimiter", "cat <<EOF\n{{.x}}\nEOF", "OF"},
+		{"lines within delimiter", "cat <<EOF\n{{.x}}\nEOF", "E\nO\nF"},
+		{"delimiter within line", "cat <<EOF\n{{.x}}\nEOF", "an EOF b"},
+		{"delimiter with text", "cat <<EOF\nit's {{.x}}\nEOF", "EOF"},
+		{"tab stripped heredoc", "cat <<-EOF\n\t{{.x}}\nEOF", "EO"},
+		{"quoted heredoc", "cat <<'EOF'\n{{.x}}\nEOF", "EO"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := NewScript(test.script).WithField("x", test.value).WithEscaping(ShellEscaping).Compile()
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			if output := runShell(t, "sh", script.Raw()); !strings.Contains(output, test.value+"\n") {
+				t.Errorf("output %q does not contain the value, script:\n%s", output, script.Raw())
+			}
+		})
+	}
+}
+
+func TestShellEscapingIncludedTemplates(t *testing.T) {
+	library := NewScriptLibrary().Add("value", "v={{.x}}")
+	tests := []struct {
+		name   string
+		shell  string
+		script string
+	}{
+		{"template bare", "sh", `echo {{template "value" .}}`},
+		{"template single quoted", "sh", `echo '{{template "value" .}}'`},
+		{"template double quoted", "sh", `echo "{{template "value" .}}"`},
+		{"template ansi quoted", "bash", `echo $'{{template "value" .}}'`},
+		{"template in heredoc", "sh", "cat <<EOF\n{{template \"value\" .}}\nEOF"},
+		{"include double quoted", "sh", `echo "{{include "value" .}}"`},
+		{"include in every context", "sh", `echo {{include "value" .}} "{{include "value" .}}" '{{include "value" .}}'`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pwned := filepath.Join(t.TempDir(), "pwned")
+			value := `a'; touch ` + pwned + ` #"$(touch ` + pwned + `)` + "`touch " + pwned + "`\\"
+			script, err := NewScript(test.script).WithLibrary(library).WithField("x", value).WithEscaping(ShellEscaping).Compile()
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			output := runShell(t, test.shell, script.Raw())
+			if _, err := os.Stat(pwned); err == nil {
+				t.Fatalf("value was executed by the script:\n%s", script.Raw())
+			}
+			if strings.Count(output, "v="+value) != strings.Count(test.script, `"value"`) {
+				t.Errorf("output %q does not contain the value, script:\n%s", output, script.Raw())
+			}
+		})
+	}
+}
+
+func TestShellEscapingShquote(t *testing.T) {
+	value := `it's "$HOME"`
+	tests := map[string]string{
+		"bare":          "echo {{shquote .x}}",
+		"double quoted": `echo "{{shquote .x}}"`,
+		"single quoted": "echo '{{.x | shquote}}'",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			script, err := NewScript(text).WithField("x", value).WithEscaping(ShellEscaping).Compile()
+			if err != nil {
+				t.Fatalf("failed to compile: %v", err)
+			}
+			if output := runShell(t, "sh", script.Raw()); output != value+"\n" {
+				t.Errorf("expected output %q, got %q, script:\n%s", value+"\n", output, script.Raw())
+			}
+		})
+	}
+}
+
+func TestShellEscapingRejectsAmbiguousContext(t *testing.T) {
+	tests := map[string]string{
+		"parameter expansion": "echo ${x:-{{.x}}}",
+		"backquotes":          "echo `echo {{.x}}`",
+		"after dollar":        "echo ${{.x}}",
+		"after backslash":     `echo \{{.x}}`,
+		"heredoc delimiter":   "cat <<{{.x}}\nbody\n",
+		"dynamic include":     `echo "{{include .x .}}"{{define "v"}}v{{end}}`,
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			script := NewScript(text).WithField("x", "v").WithEscaping(ShellEscaping)
+			if _, err := script.Compile(); err == nil {
+				t.Error("expected compilation to fail")
+			}
+		})
+	}
+}
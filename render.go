@@ -0,0 +1,119 @@
+package nescript
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// ScriptTemplate is a script whose template has been parsed once, so that it
+// can be rendered many times with different data (for example, once for each
+// node in a topology) without being re-parsed. Rendering does not modify the
+// template, thus it can be rendered concurrently.
+type ScriptTemplate struct {
+	script Script
+	tmpl   *template.Template
+}
+
+// CmdTemplate is a command whose argument templates have been parsed once, so
+// that it can be rendered many times with different data without being
+// re-parsed (see ScriptTemplate).
+type CmdTemplate struct {
+	cmd   Cmd
+	tmpls []*template.Template
+}
+
+// Template parses the script's template, returning a handle that can be used
+// to render the script many times. The script's current data is used as the
+// base data for every render. If the script has already been compiled, every
+// render returns it unchanged. This errors if the template can not be parsed.
+func (s Script) Template() (*ScriptTemplate, error) {
+	if s.options.compiled {
+		return &ScriptTemplate{
+			script: s,
+		}, nil
+	}
+	tmpl, err := s.options.parse(s.raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the script: %w", err)
+	}
+	return &ScriptTemplate{
+		script: s,
+		tmpl:   tmpl,
+	}, nil
+}
+
+// Render compiles the script using the script's data merged with the given
+// data (where the given data takes precedence). The returned script is
+// compiled (so compiling it again has no effect), whereas the template (and
+// the script it was created from) is left unchanged.
+func (st *ScriptTemplate) Render(data map[string]any) (Script, error) {
+	s := st.script
+	if st.tmpl == nil {
+		return s, nil
+	}
+	merged := s.dynamicData.mergedData(data)
+	if err := s.options.validate(merged); err != nil {
+		return s, fmt.Errorf("script fields are invalid: %w", err)
+	}
+	compiledRaw := &bytes.Buffer{}
+	if err := st.tmpl.Execute(compiledRaw, merged); err != nil {
+		return s, fmt.Errorf("script template could not be compiled: %w", err)
+	}
+	s.raw = compiledRaw.String()
+	s.dynamicData = s.dynamicData.compiled()
+	s.options.compiled = true
+	return s, nil
+}
+
+// Template parses the templates of the command's arguments, returning a handle
+// that can be used to render the command many times. The command's current
+// data is used as the base data for every render. If the command has already
+// been compiled, every render returns it unchanged. This errors if any
+// argument's template can not be parsed.
+func (c Cmd) Template() (*CmdTemplate, error) {
+	if c.options.compiled {
+		return &CmdTemplate{
+			cmd: c,
+		}, nil
+	}
+	tmpls := make([]*template.Template, len(c.args))
+	for idx, a := range c.args {
+		argTemplate, err := c.options.parse(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse a command arg: %w", err)
+		}
+		tmpls[idx] = argTemplate
+	}
+	return &CmdTemplate{
+		cmd:   c,
+		tmpls: tmpls,
+	}, nil
+}
+
+// Render compiles the command using the command's data merged with the given
+// data (where the given data takes precedence). The returned command is
+// compiled (so compiling it again has no effect), whereas the template (and
+// the command it was created from) is left unchanged.
+func (ct *CmdTemplate) Render(data map[string]any) (Cmd, error) {
+	c := ct.cmd
+	if ct.tmpls == nil {
+		return c, nil
+	}
+	merged := c.dynamicData.mergedData(data)
+	if err := c.options.validate(merged); err != nil {
+		return c, fmt.Errorf("cmd fields are invalid: %w", err)
+	}
+	compiledArgs := make([]string, len(ct.tmpls))
+	for idx, argTemplate := range ct.tmpls {
+		compiledArg := &bytes.Buffer{}
+		if err := argTemplate.Execute(compiledArg, merged); err != nil {
+			return c, fmt.Errorf("cmd arg template could not be compiled: %w", err)
+		}
+		compiledArgs[idx] = compiledArg.String()
+	}
+	c.args = compiledArgs
+	c.dynamicData = c.dynamicData.compiled()
+	c.options.compiled = true
+	return c, nil
+}
@@ -0,0 +1,54 @@
+package nescript
+
+import (
+	"testing"
+)
+
+const requiredInputScript = `# ---
+# inputs:
+#   iface: {type: string, required: true}
+# ---
+echo {{.iface}}`
+
+func TestCompileIsIdempotent(t *testing.T) {
+	script, err := ParseScript(requiredInputScript)
+	if err != nil {
+		t.Fatalf("failed to parse script: %v", err)
+	}
+	compiled, err := script.WithField("iface", "{{.x}}").Compile()
+	if err != nil {
+		t.Fatalf("failed to compile script: %v", err)
+	}
+	recompiled, err := compiled.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile compiled script: %v", err)
+	}
+	if recompiled.Raw() != compiled.Raw() {
+		t.Errorf("compiled script changed when compiled again: %q != %q", recompiled.Raw(), compiled.Raw())
+	}
+	fields, err := compiled.Fields()
+	if err != nil || len(fields) != 0 {
+		t.Errorf("compiled script has fields %v (%v)", fields, err)
+	}
+	cmd, err := compiled.Cmd().Compile()
+	if err != nil {
+		t.Fatalf("failed to compile cmd of compiled script: %v", err)
+	}
+	if raw := cmd.Raw(); raw[len(raw)-1] != compiled.Raw() {
+		t.Errorf("cmd of compiled script was templated again: %q", raw[len(raw)-1])
+	}
+}
+
+func TestCmdCompileIsIdempotent(t *testing.T) {
+	compiled, err := NewCmd("echo", "{{.msg}}").WithField("msg", "{{.x}}").WithStrict(true).Compile()
+	if err != nil {
+		t.Fatalf("failed to compile cmd: %v", err)
+	}
+	recompiled, err := compiled.Compile()
+	if err != nil {
+		t.Fatalf("failed to compile compiled cmd: %v", err)
+	}
+	if recompiled.Raw()[1] != "{{.x}}" {
+		t.Errorf("compiled cmd was templated again: %q", recompiled.Raw()[1])
+	}
+}
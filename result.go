@@ -13,6 +13,27 @@ type Result struct {
 	ExitCode int    `json:"exitCode"`
 
 	TotalTime time.Duration `json:"executionTime"`
+
+	// Usage holds the resources used by the process, if they could be obtained.
+	Usage *Usage `json:"usage,omitempty"`
+
+	// PipeStatus holds the exit code of each stage of a pipeline, in order,
+	// when the result is of a pipeline (see Pipeline).
+	PipeStatus []int `json:"pipeStatus,omitempty"`
+
+	// Attempts holds the result of every attempt made at executing the script
+	// when it has been retried (see RetryMiddleware).
+	Attempts []Result `json:"attempts,omitempty"`
+}
+
+// Usage represents the resources used by a process during its execution. Not
+// every executor can provide every figure, in which case the value is 0.
+type Usage struct {
+	UserTime                   time.Duration `json:"userTime"`
+	SystemTime                 time.Duration `json:"systemTime"`
+	MaxRSS                     int64         `json:"maxRSS"`
+	VoluntaryContextSwitches   int64         `json:"voluntaryContextSwitches"`
+	InvoluntaryContextSwitches int64         `json:"involuntaryContextSwitches"`
 }
 
 // Output parses the specified outputs from the script's stdOut (or stdErr if
@@ -0,0 +1,281 @@
+package nescript
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// RetryCondition determines if an attempt at executing a cmd should be
+// retried based on its result, or the error returned when executing it or
+// waiting for its result (in which case the result will be nil).
+type RetryCondition func(*Result, error) bool
+
+// RetryPolicy configures how a cmd is retried by the RetryMiddleware. A cmd
+// is retried whilst any of the conditions holds true and the maximum number of
+// attempts has not been reached. The delay between attempts grows
+// exponentially from the initial delay by the multiplier, up to the max delay
+// (if set), with a random jitter applied as a fraction of the delay.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+	Conditions   []RetryCondition
+}
+
+// DefaultRetryPolicy returns a policy that will attempt execution up to 3
+// times, retrying if the executor errors, with a delay starting at 1s.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:  3,
+		InitialDelay: time.Second,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+		Conditions:   []RetryCondition{RetryOnError()},
+	}
+}
+
+// WithConditions returns a copy of the policy with the given conditions added
+// to those currently held by the policy.
+func (rp RetryPolicy) WithConditions(conditions ...RetryCondition) RetryPolicy {
+	rp.Conditions = append(append([]RetryCondition{}, rp.Conditions...), conditions...)
+	return rp
+}
+
+// Delay returns the time to wait before making the given attempt (where the
+// first attempt is 1). The first attempt is never delayed.
+func (rp RetryPolicy) Delay(attempt int) time.Duration {
+	if attempt <= 1 || rp.InitialDelay <= 0 {
+		return 0
+	}
+	multiplier := rp.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(rp.InitialDelay) * math.Pow(multiplier, float64(attempt-2))
+	if rp.MaxDelay > 0 && delay > float64(rp.MaxDelay) {
+		delay = float64(rp.MaxDelay)
+	}
+	if rp.Jitter > 0 {
+		delay += delay * rp.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// shouldRetry returns true if any of the policy's conditions hold true for the
+// given result/error.
+func (rp RetryPolicy) shouldRetry(result *Result, err error) bool {
+	for _, condition := range rp.Conditions {
+		if condition != nil && condition(result, err) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryOnError retries an attempt if the executor failed to execute the cmd,
+// or the result of the process could not be obtained.
+func RetryOnError() RetryCondition {
+	return func(r *Result, err error) bool {
+		return err != nil
+	}
+}
+
+// RetryOnExitCode retries an attempt if the process exited with any of the
+// given exit codes. If no codes are given, any non-zero exit code will cause
+// a retry.
+func RetryOnExitCode(codes ...int) RetryCondition {
+	return func(r *Result, err error) bool {
+		if err != nil || r == nil {
+			return false
+		}
+		if len(codes) == 0 {
+			return r.ExitCode != 0
+		}
+		for _, code := range codes {
+			if r.ExitCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryUntilExpression retries an attempt unless the given expression
+// evaluates to true using the combined output of the result. If the expression
+// can not be evaluated, the attempt is also retried.
+func RetryUntilExpression(evaluator EvalFunc, expression string) RetryCondition {
+	return func(r *Result, err error) bool {
+		if err != nil || r == nil {
+			return false
+		}
+		ok, err := r.CombinedOutput().Evaluate(evaluator, expression)
+		return err != nil || !ok
+	}
+}
+
+// RetryMiddleware re-executes a cmd according to the given policy. Failures to
+// execute the cmd are retried before the process is returned, whereas
+// conditions based on the result are checked when Result is called on the
+// returned process. The returned result is that of the final attempt, with the
+// results of every attempt (including the last) held in its Attempts. If a
+// retry can not be started, the result of the latest attempt is returned (with
+// every attempt so far) along with the error. No further attempts are made
+// once the given context is done (if not nil), or the process is killed or
+// closed, including whilst waiting between attempts.
+func RetryMiddleware(ctx context.Context, policy RetryPolicy) Middleware {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			process := retryProcess{
+				ctx:    ctx,
+				stop:   make(chan struct{}),
+				next:   next,
+				cmd:    c,
+				policy: policy,
+			}
+			if err := process.start(); err != nil {
+				return nil, err
+			}
+			return &process, nil
+		}
+	}
+}
+
+// retryProcess is a process that may be made up of multiple attempts at
+// executing a cmd, where the current attempt is the one being controlled.
+type retryProcess struct {
+	ctx      context.Context
+	stop     chan struct{}
+	stopOnce sync.Once
+	next     ExecFunc
+	cmd      Cmd
+	policy   RetryPolicy
+	attempt  int
+	attempts []Result
+	current  Process
+	lock     sync.Mutex
+}
+
+// start executes the cmd until either a process is created, or the policy
+// determines the error should not be retried.
+func (p *retryProcess) start() error {
+	for {
+		p.attempt++
+		if err := p.wait(p.policy.Delay(p.attempt)); err != nil {
+			return err
+		}
+		process, err := p.next(p.cmd)
+		if err == nil {
+			p.lock.Lock()
+			p.current = process
+			p.lock.Unlock()
+			return nil
+		}
+		if p.attempt >= p.policy.MaxAttempts || !p.policy.shouldRetry(nil, err) {
+			return err
+		}
+	}
+}
+
+// wait waits for the delay before an attempt, erroring if the context is done
+// or the process is stopped first.
+func (p *retryProcess) wait(delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return fmt.Errorf("retries were cancelled: %w", p.ctx.Err())
+		case <-p.stop:
+			return errors.New("process was stopped")
+		default:
+		}
+		select {
+		case <-p.ctx.Done():
+		case <-p.stop:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// stopRetrying prevents any further attempts.
+func (p *retryProcess) stopRetrying() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// process returns the process of the current attempt.
+func (p *retryProcess) process() Process {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.current
+}
+
+func (p *retryProcess) Kill() error {
+	p.stopRetrying()
+	return p.process().Kill()
+}
+
+func (p *retryProcess) Signal(s os.Signal) error {
+	return p.process().Signal(s)
+}
+
+func (p *retryProcess) Write(input string) error {
+	return p.process().Write(input)
+}
+
+func (p *retryProcess) Result() (*Result, error) {
+	for {
+		result, err := p.process().Result()
+		if result != nil {
+			p.attempts = append(p.attempts, *result)
+		}
+		if p.attempt >= p.policy.MaxAttempts || !p.policy.shouldRetry(result, err) {
+			if result != nil {
+				result.Attempts = p.attempts
+			}
+			return result, err
+		}
+		p.process().Close()
+		if startErr := p.start(); startErr != nil {
+			return p.latest(result), fmt.Errorf("failed to start attempt %d: %w", p.attempt, startErr)
+		}
+	}
+}
+
+// latest returns the given result of the latest attempt (or if nil, that of the
+// latest attempt to have a result), with the results of every attempt so far.
+// Nil is returned if no attempt has a result.
+func (p *retryProcess) latest(result *Result) *Result {
+	if result == nil {
+		if len(p.attempts) == 0 {
+			return nil
+		}
+		latest := p.attempts[len(p.attempts)-1]
+		result = &latest
+	}
+	result.Attempts = p.attempts
+	return result
+}
+
+func (p *retryProcess) Close() {
+	p.stopRetrying()
+	p.process().Close()
+}
@@ -0,0 +1,103 @@
+package nescript
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryMiddlewareKeepsAttemptsWhenRetryFailsToStart(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	startErr := errors.New("connection refused")
+	executor := RetryMiddleware(nil, RetryPolicy{
+		MaxAttempts: 3,
+		Conditions:  []RetryCondition{RetryOnExitCode()},
+	})(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		if len(cmds) > 1 {
+			return nil, startErr
+		}
+		return &fakeProcess{result: &Result{StdOut: "first", ExitCode: 1}}, nil
+	}))
+	process, err := NewCmd("true").Exec(executor)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if !errors.Is(err, startErr) {
+		t.Errorf("expected the start error, got %v", err)
+	}
+	if result == nil {
+		t.Fatal("result of the first attempt was dropped")
+	}
+	if result.StdOut != "first" || result.ExitCode != 1 || len(result.Attempts) != 1 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(cmds) != 2 {
+		t.Errorf("expected 2 attempts to start, got %d", len(cmds))
+	}
+}
+
+func TestRetryMiddlewareRetriesExitCode(t *testing.T) {
+	cmds := make([]Cmd, 0)
+	executor := RetryMiddleware(nil, RetryPolicy{
+		MaxAttempts: 3,
+		Conditions:  []RetryCondition{RetryOnExitCode()},
+	})(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+		return &fakeProcess{result: &Result{ExitCode: 3 - len(cmds)}}, nil
+	}))
+	process, err := NewCmd("true").Exec(executor)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if result.ExitCode != 0 || len(result.Attempts) != 3 || result.Attempts[0].ExitCode != 2 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestRetryMiddlewareStopsWaitingBetweenAttempts(t *testing.T) {
+	tests := map[string]func(cancel context.CancelFunc, process Process){
+		"cancelled": func(cancel context.CancelFunc, _ Process) { cancel() },
+		"killed":    func(_ context.CancelFunc, process Process) { process.Kill() },
+	}
+	for name, stop := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cmds := make([]Cmd, 0)
+			executor := RetryMiddleware(ctx, RetryPolicy{
+				MaxAttempts:  3,
+				InitialDelay: time.Hour,
+				Conditions:   []RetryCondition{RetryOnExitCode()},
+			})(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+				return &fakeProcess{result: &Result{ExitCode: 1}}, nil
+			}))
+			process, err := executor(*NewCmd("false"))
+			if err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				stop(cancel, process)
+			}()
+			done := make(chan struct{})
+			var result *Result
+			go func() {
+				result, err = process.Result()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("retry did not stop waiting")
+			}
+			if err == nil || result == nil || len(result.Attempts) != 1 || len(cmds) != 1 {
+				t.Errorf("unexpected outcome: %+v, %v, %d attempts started", result, err, len(cmds))
+			}
+		})
+	}
+}
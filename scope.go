@@ -0,0 +1,145 @@
+package nescript
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TeardownFunc undoes the changes made by some earlier work, such as removing
+// a tc qdisc or stopping a background capture. The result (if any) of the
+// teardown's process is returned.
+type TeardownFunc func(context.Context) (*Result, error)
+
+// Scope collects teardowns as work is done, so that they can all be executed
+// once the work is complete, however the work completes (see RunScope).
+// Teardowns are executed in the reverse order that they were registered, such
+// that later changes are undone first. It is safe to register teardowns from
+// many goroutines.
+type Scope struct {
+	// TeardownTimeout is the maximum time each teardown can take to execute,
+	// after which it is killed. If 0, teardowns are not time limited.
+	TeardownTimeout time.Duration
+
+	teardowns []namedTeardown
+	lock      sync.Mutex
+}
+
+// TeardownResult is the outcome of a single teardown of a scope.
+type TeardownResult struct {
+	Name string `json:"name"`
+
+	// Result is the result of the teardown's process, or nil if a result was
+	// not obtained.
+	Result *Result `json:"result,omitempty"`
+
+	// Err is the reason the teardown failed, if it did. A teardown whose
+	// process exits with a non-zero exit code is considered failed.
+	Err error `json:"-"`
+}
+
+// namedTeardown is a teardown registered with a scope.
+type namedTeardown struct {
+	name     string
+	teardown TeardownFunc
+}
+
+// NewScope creates a scope with no teardowns.
+func NewScope() *Scope {
+	return &Scope{
+		teardowns: make([]namedTeardown, 0),
+	}
+}
+
+// Defer registers a cmd to be executed via the given executor when the scope
+// is torn down. The cmd is compiled when it is executed.
+func (s *Scope) Defer(name string, cmd Cmd, executor ExecFunc) {
+	s.DeferFunc(name, func(ctx context.Context) (*Result, error) {
+		process, err := cmd.CompileExec(executor)
+		if err != nil {
+			return nil, err
+		}
+		defer process.Close()
+		return WaitContext(ctx, process)
+	})
+}
+
+// DeferScript registers a script to be executed via the given executor when
+// the scope is torn down (see Defer).
+func (s *Scope) DeferScript(name string, script Script, executor ExecFunc) {
+	s.Defer(name, script.Cmd(), executor)
+}
+
+// DeferFunc registers a function to be called when the scope is torn down.
+func (s *Scope) DeferFunc(name string, teardown TeardownFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.teardowns = append(s.teardowns, namedTeardown{
+		name:     name,
+		teardown: teardown,
+	})
+}
+
+// Teardown executes every registered teardown in reverse order, waiting for
+// each to complete before starting the next. Every teardown is executed, even
+// if an earlier one fails or panics, and the teardowns are then cleared, so
+// calling this again only executes teardowns registered since. As teardowns
+// must run even when the work was cancelled, they are not given the context
+// of the work (see TeardownTimeout).
+func (s *Scope) Teardown() []TeardownResult {
+	s.lock.Lock()
+	teardowns := s.teardowns
+	s.teardowns = make([]namedTeardown, 0)
+	s.lock.Unlock()
+	results := make([]TeardownResult, 0, len(teardowns))
+	for idx := len(teardowns) - 1; idx >= 0; idx-- {
+		results = append(results, s.runTeardown(teardowns[idx]))
+	}
+	return results
+}
+
+// runTeardown executes a single teardown, recovering from any panic.
+func (s *Scope) runTeardown(teardown namedTeardown) (teardownResult TeardownResult) {
+	teardownResult.Name = teardown.name
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			teardownResult.Err = fmt.Errorf("teardown panicked: %v", recovered)
+		}
+	}()
+	ctx := context.Background()
+	if s.TeardownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.TeardownTimeout)
+		defer cancel()
+	}
+	result, err := teardown.teardown(ctx)
+	teardownResult.Result = result
+	if err != nil {
+		teardownResult.Err = err
+	} else if result != nil && result.ExitCode != 0 {
+		teardownResult.Err = fmt.Errorf("exited with code %d", result.ExitCode)
+	}
+	return teardownResult
+}
+
+// RunScope calls the given function with a new scope, then executes every
+// teardown registered with the scope, regardless of whether the function
+// succeeds, returns an error or panics. If the function panics, the panic
+// continues once the teardowns have been executed. The function should return
+// promptly once the context is cancelled (e.g. via signal.NotifyContext when
+// the process is interrupted), so that the teardowns can be executed. The
+// results of the teardowns are returned separately from the error returned by
+// the function.
+func RunScope(ctx context.Context, teardownTimeout time.Duration, fn func(context.Context, *Scope) error) (teardowns []TeardownResult, err error) {
+	scope := NewScope()
+	scope.TeardownTimeout = teardownTimeout
+	defer func() {
+		recovered := recover()
+		teardowns = scope.Teardown()
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+	return nil, fn(ctx, scope)
+}
@@ -1,13 +1,11 @@
 package nescript
 
 import (
-	"bytes"
+	"context"
 	"fmt"
-	"html/template"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
+	"path/filepath"
+	"text/template"
 )
 
 // Script is some executable string, along with data to supplement its
@@ -18,6 +16,8 @@ import (
 type Script struct {
 	raw        string
 	subcommand Subcommand
+	options    templateOptions
+	metadata   *ScriptMetadata
 	*dynamicData
 }
 
@@ -25,9 +25,24 @@ var (
 	defaultSubcommand Subcommand = SCShell
 )
 
-// NewScript creates a script based on the raw executable string.
+// NewScript creates a script based on the raw executable string. If the script
+// has a front-matter block (see ParseFrontMatter), its metadata is applied to
+// the script. Should the front-matter be invalid, the error is returned when
+// the script is compiled. Use ParseScript to get the error immediately.
 func NewScript(raw string) *Script {
-	script := Script{
+	script := newScript(raw)
+	if metadata, err := ParseFrontMatter(raw); err != nil {
+		script.options.err = fmt.Errorf("invalid front-matter: %w", err)
+	} else {
+		script = script.withMetadata(metadata)
+	}
+	return &script
+}
+
+// newScript creates a script based on the raw executable string, ignoring any
+// front-matter.
+func newScript(raw string) Script {
+	return Script{
 		raw:        raw,
 		subcommand: defaultSubcommand,
 		dynamicData: &dynamicData{
@@ -35,40 +50,41 @@ func NewScript(raw string) *Script {
 			env:  make([]string, 0),
 		},
 	}
-	return &script
+}
+
+// ParseScript creates a script based on the raw executable string, applying
+// the metadata from its front-matter block (see ParseFrontMatter). This errors
+// if the front-matter is invalid.
+func ParseScript(raw string) (*Script, error) {
+	script := NewScript(raw)
+	if script.options.err != nil {
+		return nil, script.options.err
+	}
+	return script, nil
 }
 
 // NewScriptFromFile creates a Script from the string extracted from a given
-// file. This can error if the file can not be read.
+// file. As with NewScriptFromFS, any metadata file alongside the script is also
+// applied. This can error if the file or its metadata can not be read.
 func NewScriptFromFile(path string) (*Script, error) {
-	fileBytes, err := os.ReadFile(path)
+	script, err := NewScriptFromFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get script from file: %w", err)
 	}
-	return NewScript(string(fileBytes)), nil
+	return script, nil
 }
 
 // NewScriptFromHTTP creates a Script from the string extracted from a given
-// URL. This can error if the contents of the remote resource can not be read.
+// URL. This can error if the contents of the remote resource can not be read,
+// or the response has a non-2xx status. For control over the request (context,
+// client, caching and integrity checks) use a ScriptFetcher.
 func NewScriptFromHTTP(link string) (*Script, error) {
-	scriptURL, err := url.Parse(link)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse given link as a url: %w", err)
-	}
-	if response, err := http.Get(scriptURL.String()); err != nil {
-		return nil, fmt.Errorf("could not get script from url: %w", err)
-	} else {
-		defer response.Body.Close()
-		if bodyBytes, err := io.ReadAll(response.Body); err != nil {
-			return nil, fmt.Errorf("could not read the downloaded script: %w", err)
-		} else {
-			return NewScript(string(bodyBytes)), nil
-		}
-	}
+	return ScriptFetcher{}.Fetch(context.Background(), link, "")
 }
 
 // Raw returns the raw executable string as is. If the script contains template
-// handlebars, they will be returned as provided, not compiled.
+// handlebars, they will be returned as provided, not compiled. Secret values are
+// not masked (see Redact).
 func (s Script) Raw() string {
 	return s.raw
 }
@@ -78,6 +94,38 @@ func (s Script) WithSubcommand(sc Subcommand) Script {
 	return s
 }
 
+// WithFuncs adds functions that can be used by the script's template, in
+// addition to the builtin and default functions (see DefaultFuncs). Functions
+// with the same name as an existing function replace it.
+func (s Script) WithFuncs(funcs template.FuncMap) Script {
+	s.options = s.options.withFuncs(funcs)
+	return s
+}
+
+// WithStrict sets whether compiling the script should error if its template
+// references a field that has not been set. By default, missing fields are
+// replaced with "<no value>".
+func (s Script) WithStrict(strict bool) Script {
+	s.options.strict = strict
+	return s
+}
+
+// WithLibrary sets the library of templates that the script can refer to by
+// name, via the template action or the include function (see ScriptLibrary).
+func (s Script) WithLibrary(library *ScriptLibrary) Script {
+	s.options.library = library
+	return s
+}
+
+// WithEscaping sets how values are escaped when inserted into the script when
+// it is compiled. For scripts executed by a POSIX shell (sh, bash, etc...),
+// ShellEscaping ensures that values are always treated as literal words. By
+// default, values are inserted as-is (NoEscaping).
+func (s Script) WithEscaping(escaping Escaping) Script {
+	s.options.escaping = escaping
+	return s
+}
+
 // WithField adds a key/value to the map of template data to be used when
 // compiling the script. If the key already exists, it is overwritten.
 func (s Script) WithField(key string, value any) Script {
@@ -93,6 +141,32 @@ func (s Script) WithFields(fields map[string]any, overwrite bool) Script {
 	return s
 }
 
+// WithStruct adds the exported fields of the struct (or pointer to a struct) to
+// the map of template data, replacing any existing keys (see StructFields for
+// how fields are named). This errors, leaving the data unchanged, if v is not a
+// struct, or if any field referenced by the script (see Fields) would not be
+// set.
+func (s Script) WithStruct(v any) (Script, error) {
+	referenced, err := s.Fields()
+	if err != nil {
+		return s, err
+	}
+	if err := s.bindStruct(v, referenced); err != nil {
+		return s, fmt.Errorf("failed to bind struct to script: %w", err)
+	}
+	return s, nil
+}
+
+// WithSecretField adds a key/value to the map of template data (as with
+// WithField), marking the value as secret. Secret values are still used when
+// compiling the script, however are masked when the script is formatted or
+// logged, and in the output and errors of processes it is executed as. Only
+// strings can be secret (see Cmd.WithSecretField).
+func (s Script) WithSecretField(key string, value string) Script {
+	s.addSecretField(key, value)
+	return s
+}
+
 // WithEnv takes one or more environmental variables in KEY=VALUE format. These
 // will be used when executing the script.
 func (s Script) WithEnv(env ...string) Script {
@@ -100,6 +174,13 @@ func (s Script) WithEnv(env ...string) Script {
 	return s
 }
 
+// WithSecretEnv takes one or more environmental variables in KEY=VALUE format
+// (as with WithEnv), marking their values as secret (see WithSecretField).
+func (s Script) WithSecretEnv(env ...string) Script {
+	s.addSecretEnv(env...)
+	return s
+}
+
 // WithLocalOSEnv appends the environmental variables from the local system to
 // the env var set currently held be the script.
 func (s Script) WithLocalOSEnv() Script {
@@ -109,22 +190,30 @@ func (s Script) WithLocalOSEnv() Script {
 
 // Compile uses the go template engine and the provided data fields to compile
 // the script. These in-turn act a more portable approach than command-line
-// arguments.
+// arguments. The returned script has no data and is not templated again if
+// compiled again, whilst the script it was compiled from is left unchanged,
+// so can be compiled again (see Template to avoid re-parsing the template each
+// time).
 func (s Script) Compile() (Script, error) {
-	scriptTemplate, err := template.New("").Parse(s.raw)
+	scriptTemplate, err := s.Template()
 	if err != nil {
-		return s, fmt.Errorf("failed to parse the script: %w", err)
+		return s, err
 	}
-	if s.data == nil {
-		s.data = make(map[string]any)
-	}
-	compiledRaw := &bytes.Buffer{}
-	if err := scriptTemplate.Execute(compiledRaw, s.data); err != nil {
-		return s, fmt.Errorf("script template could not be compiled: %w", err)
+	compiled, err := scriptTemplate.Render(nil)
+	if err != nil {
+		return s, err
 	}
-	s.raw = compiledRaw.String()
-	s.data = make(map[string]any)
-	return s, nil
+	return compiled, nil
+}
+
+// Fields returns the sorted paths of every field referenced by the script's
+// template, relative to the template data. Nested fields are given as a dot
+// separated path, e.g. "node.addr". Fields referenced within range and with
+// blocks are only included if referenced via $ (e.g. {{$.Name}}), as
+// otherwise they are relative to the values being iterated over. This errors
+// if the script's template can not be parsed.
+func (s Script) Fields() ([]string, error) {
+	return s.options.fields(s.raw)
 }
 
 // MustCompile compiles the script, however will panic if an error occurs.
@@ -152,6 +241,7 @@ func (s Script) Cmd() Cmd {
 		cmd = NewCmd(command[0], command[1:]...)
 	}
 	cmd.dynamicData = s.dynamicData
-	cmd.formatter = defaultScriptFormatter
+	cmd.defaultFormatter = defaultScriptFormatter
+	cmd.options = s.options
 	return *cmd
 }
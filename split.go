@@ -0,0 +1,178 @@
+package nescript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// envAssignmentRegex matches the start of a word that assigns an env var,
+	// e.g. "FOO=bar".
+	envAssignmentRegex *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// splitWord is a word produced by splitting a command string, along with the
+// index (within the word) of its first quoted or escaped character, or -1 if
+// it has none.
+type splitWord struct {
+	text        string
+	quotedIndex int
+}
+
+// isAssignment determines if the word is an env var assignment, which is only
+// the case if the name and the "=" are not quoted.
+func (sw splitWord) isAssignment() bool {
+	match := envAssignmentRegex.FindString(sw.text)
+	return match != "" && (sw.quotedIndex < 0 || sw.quotedIndex >= len(match))
+}
+
+// splitCommand splits the string into words in the same way as a POSIX shell,
+// handling single quotes, double quotes, backslash escapes and comments. No
+// expansion is performed, thus constructs that would require it (parameter
+// expansion, command substitution and $'...' quoting), as well as operators
+// (pipes, lists, redirections and subshells) and unquoted newlines (other
+// than at the end), result in an error. Template actions (e.g.
+// {{.addr | shquote}}) outside of single quotes are kept verbatim, so can
+// contain spaces, quotes and pipes.
+func splitCommand(s string) ([]splitWord, error) {
+	words := make([]splitWord, 0)
+	var word strings.Builder
+	inWord := false
+	quotedIndex := -1
+	markQuoted := func() {
+		inWord = true
+		if quotedIndex < 0 {
+			quotedIndex = word.Len()
+		}
+	}
+	endWord := func() {
+		if inWord {
+			words = append(words, splitWord{text: word.String(), quotedIndex: quotedIndex})
+		}
+		word.Reset()
+		inWord = false
+		quotedIndex = -1
+	}
+	runes := []rune(s)
+	for idx := 0; idx < len(runes); idx++ {
+		r := runes[idx]
+		if end := templateActionEnd(runes, idx); end > 0 {
+			inWord = true
+			word.WriteString(string(runes[idx:end]))
+			idx = end - 1
+			continue
+		}
+		switch {
+		case r == '\n' && strings.TrimSpace(string(runes[idx:])) != "":
+			return nil, fmt.Errorf("unsupported newline at position %d, as it would start another command", idx)
+		case r == ' ' || r == '\t' || r == '\n':
+			endWord()
+		case r == '#' && !inWord:
+			for idx+1 < len(runes) && runes[idx+1] != '\n' {
+				idx++
+			}
+		case r == '\\':
+			if idx+1 >= len(runes) {
+				return nil, fmt.Errorf("unterminated escape at position %d", idx)
+			}
+			idx++
+			if runes[idx] == '\n' {
+				continue
+			}
+			markQuoted()
+			word.WriteRune(runes[idx])
+		case r == '\'':
+			end := indexRune(runes, '\'', idx+1)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote at position %d", idx)
+			}
+			markQuoted()
+			word.WriteString(string(runes[idx+1 : end]))
+			idx = end
+		case r == '"':
+			markQuoted()
+			start := idx
+			for idx++; ; idx++ {
+				if idx >= len(runes) {
+					return nil, fmt.Errorf("unterminated double quote at position %d", start)
+				}
+				if runes[idx] == '"' {
+					break
+				}
+				if end := templateActionEnd(runes, idx); end > 0 {
+					word.WriteString(string(runes[idx:end]))
+					idx = end - 1
+					continue
+				}
+				if err := checkExpansion(runes, idx); err != nil {
+					return nil, err
+				}
+				if runes[idx] == '\\' && idx+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[idx+1]) {
+					idx++
+					if runes[idx] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[idx])
+			}
+		case strings.ContainsRune("|&;<>()", r):
+			return nil, fmt.Errorf("unsupported shell operator '%c' at position %d", r, idx)
+		default:
+			if r == '$' && idx+1 < len(runes) && (runes[idx+1] == '\'' || runes[idx+1] == '"') {
+				return nil, fmt.Errorf("unsupported $%c...%c quoting at position %d", runes[idx+1], runes[idx+1], idx)
+			}
+			if err := checkExpansion(runes, idx); err != nil {
+				return nil, err
+			}
+			inWord = true
+			word.WriteRune(r)
+		}
+	}
+	endWord()
+	return words, nil
+}
+
+// checkExpansion errors if the rune at the index starts a command substitution
+// or parameter expansion.
+func checkExpansion(runes []rune, idx int) error {
+	switch {
+	case runes[idx] == '`':
+		return fmt.Errorf("unsupported command substitution at position %d", idx)
+	case runes[idx] == '$' && idx+1 < len(runes):
+		next := runes[idx+1]
+		if next == '(' {
+			return fmt.Errorf("unsupported command substitution at position %d", idx)
+		}
+		if next == '_' || next == '{' || (next >= 'A' && next <= 'Z') || (next >= 'a' && next <= 'z') ||
+			(next >= '0' && next <= '9') || strings.ContainsRune("@*#?$!-", next) {
+			return fmt.Errorf("unsupported parameter expansion at position %d", idx)
+		}
+	}
+	return nil
+}
+
+// templateActionEnd returns the index after the end of the template action
+// that starts at the index, or -1 if a template action does not start there.
+func templateActionEnd(runes []rune, idx int) int {
+	if idx+1 >= len(runes) || runes[idx] != '{' || runes[idx+1] != '{' {
+		return -1
+	}
+	for end := idx + 2; end+1 < len(runes); end++ {
+		if runes[end] == '}' && runes[end+1] == '}' {
+			return end + 2
+		}
+	}
+	return -1
+}
+
+// indexRune returns the index of the first occurrence of the rune at or after
+// the start index, or -1 if there is none.
+func indexRune(runes []rune, r rune, start int) int {
+	for idx := start; idx < len(runes); idx++ {
+		if runes[idx] == r {
+			return idx
+		}
+	}
+	return -1
+}
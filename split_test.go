@@ -0,0 +1,104 @@
+package nescript
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCmdFromString(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		raw  []string
+		env  []string
+	}{
+		{"command", "ls", []string{"ls"}, nil},
+		{"args", "ping -c 3  8.8.8.8", []string{"ping", "-c", "3", "8.8.8.8"}, nil},
+		{"tabs", "ls\t-l", []string{"ls", "-l"}, nil},
+		{"trailing newline", "ls -l\n", []string{"ls", "-l"}, nil},
+		{"single quotes", `echo 'a b' 'it"s' '$HOME'`, []string{"echo", "a b", `it"s`, "$HOME"}, nil},
+		{"double quotes", `echo "a b" "it's" "\$HOME" "\"q\"" "a\\b" "\x"`, []string{"echo", "a b", "it's", "$HOME", `"q"`, `a\b`, `\x`}, nil},
+		{"adjacent quotes", `echo a'b'"c"`, []string{"echo", "abc"}, nil},
+		{"empty quotes", `echo '' ""`, []string{"echo", "", ""}, nil},
+		{"quoted newline", "echo 'a\nb' \"c\nd\"", []string{"echo", "a\nb", "c\nd"}, nil},
+		{"escapes", `echo a\ b \'c\' \"d\" \$e \#f`, []string{"echo", "a b", "'c'", `"d"`, "$e", "#f"}, nil},
+		{"line continuation", "echo a \\\n b", []string{"echo", "a", "b"}, nil},
+		{"double quoted line continuation", "echo \"a\\\nb\"", []string{"echo", "ab"}, nil},
+		{"comment", "echo a # b c", []string{"echo", "a"}, nil},
+		{"comment with trailing newline", "echo a # b\n", []string{"echo", "a"}, nil},
+		{"hash within word", "echo a#b", []string{"echo", "a#b"}, nil},
+		{"lone dollar", "echo $ a$", []string{"echo", "$", "a$"}, nil},
+		{"dollar quote in double quotes", `echo "$'a'"`, []string{"echo", "$'a'"}, nil},
+		{"env prefix", "LANG=C TZ=UTC sort file", []string{"sort", "file"}, []string{"LANG=C", "TZ=UTC"}},
+		{"env prefix with quoted value", `MSG='a b' echo`, []string{"echo"}, []string{"MSG=a b"}},
+		{"env prefix with empty value", "EMPTY= env", []string{"env"}, []string{"EMPTY="}},
+		{"quoted env name", `'LANG=C' sort`, []string{"LANG=C", "sort"}, nil},
+		{"escaped env equals", `LANG\=C sort`, []string{"LANG=C", "sort"}, nil},
+		{"assignment after command", "env LANG=C", []string{"env", "LANG=C"}, nil},
+		{"invalid env name", "1A=b cmd", []string{"1A=b", "cmd"}, nil},
+		{"template action", "ping {{.addr | shquote}} -c {{ .count }}", []string{"ping", "{{.addr | shquote}}", "-c", "{{ .count }}"}, nil},
+		{"template action in double quotes", `echo "{{printf "%s" .x}}"`, []string{"echo", `{{printf "%s" .x}}`}, nil},
+		{"template action in single quotes", `echo '{{.x}}'`, []string{"echo", "{{.x}}"}, nil},
+		{"template action with newline", "echo {{.x\n}}", []string{"echo", "{{.x\n}}"}, nil},
+		{"template env value", "ADDR={{.addr}} ping", []string{"ping"}, []string{"ADDR={{.addr}}"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := NewCmdFromString(test.s)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !reflect.DeepEqual(cmd.Raw(), test.raw) {
+				t.Errorf("expected raw %q, got %q", test.raw, cmd.Raw())
+			}
+			env := test.env
+			if env == nil {
+				env = []string{}
+			}
+			if !reflect.DeepEqual(cmd.Env(), env) {
+				t.Errorf("expected env %q, got %q", env, cmd.Env())
+			}
+		})
+	}
+}
+
+func TestNewCmdFromStringRejects(t *testing.T) {
+	tests := map[string]string{
+		"empty":                      "",
+		"only whitespace":            " \t\n",
+		"only env":                   "LANG=C",
+		"only comment":               "# ls",
+		"newline":                    "echo a\nrm -rf b",
+		"newline after comment":      "echo a # b\nrm -rf c",
+		"pipe":                       "ls | wc",
+		"and":                        "true && false",
+		"or":                         "true || false",
+		"semicolon":                  "ls; ls",
+		"background":                 "sleep 1 &",
+		"redirect out":               "echo a > file",
+		"redirect in":                "cat < file",
+		"subshell":                   "(ls)",
+		"parameter expansion":        "echo $HOME",
+		"braced parameter expansion": "echo ${HOME}",
+		"special parameter":          "echo $?",
+		"positional parameter":       "echo $1",
+		"double quoted parameter":    `echo "$HOME"`,
+		"command substitution":       "echo $(id)",
+		"double quoted substitution": `echo "$(id)"`,
+		"backquotes":                 "echo `id`",
+		"double quoted backquotes":   "echo \"`id`\"",
+		"ansi-c quoting":             `echo $'a\nb'`,
+		"locale quoting":             `echo $"a"`,
+		"unterminated single quote":  "echo 'a",
+		"unterminated double quote":  `echo "a`,
+		"unterminated escape":        `echo a\`,
+		"operator after template":    "echo {{.a}} | wc",
+	}
+	for name, s := range tests {
+		t.Run(name, func(t *testing.T) {
+			if cmd, err := NewCmdFromString(s); err == nil {
+				t.Errorf("expected an error, got %q", cmd.Raw())
+			}
+		})
+	}
+}
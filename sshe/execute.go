@@ -15,7 +15,17 @@ import (
 // ExecFunc will convert the given cmd/script to a string, thus this will use
 // the formatter associated with the cmd/script.
 func Executor(target string, config *ssh.ClientConfig) nescript.ExecFunc {
-	return func(c nescript.Cmd) (nescript.Process, error) {
+	return WorkdirExecutor(target, config, "")
+}
+
+// WorkdirExecutor acts the same as Executor, however the cmd/script is executed
+// in the given working directory on the target (rather than the default
+// directory of the SSH session, usually the user's home). This is achieved by
+// changing directory before executing the cmd. If the workdir is empty, this
+// is equivalent to Executor. Secret values of the cmd are masked in the output
+// of the process and any errors.
+func WorkdirExecutor(target string, config *ssh.ClientConfig, workdir string) nescript.ExecFunc {
+	return nescript.RedactionMiddleware()(func(c nescript.Cmd) (nescript.Process, error) {
 		process := SSHProcess{}
 		sshClient, err := ssh.Dial("tcp", target, config)
 		if err != nil {
@@ -39,17 +49,21 @@ func Executor(target string, config *ssh.ClientConfig) nescript.ExecFunc {
 				return nil, fmt.Errorf("failed to set env var '%s': %w", e, err)
 			}
 		}
-		sshSession.Stdout = &process.stdoutBytes
-		sshSession.Stderr = &process.stderrBytes
+		sshSession.Stdout = c.StdoutWriter(&process.stdoutBytes)
+		sshSession.Stderr = c.StderrWriter(&process.stderrBytes)
 		if stdin, err := sshSession.StdinPipe(); err != nil {
 			return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 		} else {
 			process.stdin = stdin
 		}
-		if err := sshSession.Start(c.String()); err != nil {
+		command := c.ShellFormatted()
+		if workdir != "" {
+			command = fmt.Sprintf("cd %s && %s", nescript.ShellQuote(workdir), command)
+		}
+		if err := sshSession.Start(command); err != nil {
 			process.Close()
 			return nil, fmt.Errorf("process failed to start: %w", err)
 		}
 		return &process, nil
-	}
+	})
 }
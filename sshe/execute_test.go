@@ -0,0 +1,73 @@
+package sshe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestExecutorShellQuotesCmd(t *testing.T) {
+	target, config := startServer(t)
+	script := nescript.NewScript(`printf '%s\n' "it's" "$((1 + 1))"`).MustCompile()
+	tests := map[string]nescript.Cmd{
+		"script": script.Cmd(),
+		"cmd":    *nescript.NewCmd("printf", `%s|`, "two words", "$HOME", "it's"),
+	}
+	expected := map[string]string{
+		"script": "it's\n2\n",
+		"cmd":    "two words|$HOME|it's|",
+	}
+	for name, cmd := range tests {
+		t.Run(name, func(t *testing.T) {
+			process, err := Executor(target, config)(cmd)
+			if err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatalf("failed to get result: %v", err)
+			}
+			if result.StdOut != expected[name] {
+				t.Errorf("expected stdout %q, got %q", expected[name], result.StdOut)
+			}
+		})
+	}
+}
+
+func TestExecutorUsesCmdFormatter(t *testing.T) {
+	target, config := startServer(t)
+	cmd := nescript.NewCmd("echo", "$((1 + 1))").WithFormatter(nescript.SpaceSepFormatter())
+	process, err := Executor(target, config)(cmd)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if result.StdOut != "2\n" {
+		t.Errorf("expected the cmd's formatter to be used, got %q", result.StdOut)
+	}
+}
+
+func TestWorkdirExecutor(t *testing.T) {
+	target, config := startServer(t)
+	dir := filepath.Join(t.TempDir(), "it's a dir")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	process, err := WorkdirExecutor(target, config, dir)(*nescript.NewCmd("pwd"))
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if strings.TrimSpace(result.StdOut) != dir {
+		t.Errorf("expected workdir %q, got %q", dir, result.StdOut)
+	}
+}
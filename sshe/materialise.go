@@ -0,0 +1,57 @@
+package sshe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/willfantom/nescript"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHMaterialiser writes and removes files on an SSH target.
+type SSHMaterialiser struct {
+	target string
+	config *ssh.ClientConfig
+}
+
+// Materialiser returns a Materialiser for the given SSH target, allowing for
+// scripts to be executed as files by the SSH executor (see
+// nescript.Script.ExecFile). Files are written by streaming their content to
+// `cat` in an SSH session, thus the target only requires a POSIX shell (rather
+// than an SFTP subsystem).
+func Materialiser(target string, config *ssh.ClientConfig) nescript.Materialiser {
+	return &SSHMaterialiser{
+		target: target,
+		config: config,
+	}
+}
+
+func (m *SSHMaterialiser) WriteFile(path string, content []byte, perm os.FileMode) error {
+	command := fmt.Sprintf("cat > %s && chmod %o %s", nescript.ShellQuote(path), perm.Perm(), nescript.ShellQuote(path))
+	return m.run(command, content)
+}
+
+func (m *SSHMaterialiser) Remove(path string) error {
+	return m.run(fmt.Sprintf("rm -f %s", nescript.ShellQuote(path)), nil)
+}
+
+// run executes the command on the target in a new session, with the given
+// stdin, returning an error if the command fails.
+func (m *SSHMaterialiser) run(command string, stdin []byte) error {
+	sshClient, err := ssh.Dial("tcp", m.target, m.config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to ssh target '%s': %w", m.target, err)
+	}
+	defer sshClient.Close()
+	sshSession, err := sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create ssh session on target '%s': %w", m.target, err)
+	}
+	defer sshSession.Close()
+	sshSession.Stdin = bytes.NewReader(stdin)
+	if output, err := sshSession.CombinedOutput(command); err != nil {
+		return fmt.Errorf("command failed on ssh target '%s': %w: %s", m.target, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package sshe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestMaterialiser(t *testing.T) {
+	target, config := startServer(t)
+	path := filepath.Join(t.TempDir(), "it's a file")
+	materialiser := Materialiser(target, config)
+	if err := materialiser.WriteFile(path, []byte("line 1\nline 2\n"), 0750); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "line 1\nline 2\n" {
+		t.Fatalf("file was not written: %q %v", content, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0750 {
+		t.Errorf("file has permissions %o", info.Mode().Perm())
+	}
+	if err := materialiser.Remove(path); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file was not removed: %v", err)
+	}
+}
+
+func TestExecFile(t *testing.T) {
+	target, config := startServer(t)
+	dir := t.TempDir()
+	script := nescript.NewScript("#!/bin/sh\necho \"$0\"").MustCompile()
+	process, err := script.ExecFile(Executor(target, config), Materialiser(target, config), dir)
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	result, err := process.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if filepath.Dir(result.StdOut) != dir {
+		t.Errorf("script was not executed from a file in %s: %q", dir, result.StdOut)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("file was not removed")
+	}
+}
@@ -0,0 +1,114 @@
+package sshe
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startServer starts an SSH server that executes commands locally via sh,
+// returning its address and a client config for it.
+func startServer(t *testing.T) (string, *ssh.ClientConfig) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("failed to create host key signer: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+	return listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.Password("test")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+}
+
+// serveConn handles the sessions of a single SSH connection.
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+// serveSession handles the env and exec requests of a session.
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	env := os.Environ()
+	var once sync.Once
+	for request := range requests {
+		switch request.Type {
+		case "env":
+			var payload struct{ Key, Value string }
+			ssh.Unmarshal(request.Payload, &payload)
+			env = append(env, payload.Key+"="+payload.Value)
+			request.Reply(true, nil)
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(request.Payload, &payload)
+			request.Reply(true, nil)
+			once.Do(func() {
+				go func() {
+					defer channel.Close()
+					cmd := exec.Command("sh", "-c", payload.Command)
+					cmd.Env = env
+					cmd.Stdout = channel
+					cmd.Stderr = channel.Stderr()
+					stdin, _ := cmd.StdinPipe()
+					go func() {
+						io.Copy(stdin, channel)
+						stdin.Close()
+					}()
+					status := make([]byte, 4)
+					if err := cmd.Run(); err != nil {
+						binary.BigEndian.PutUint32(status, uint32(cmd.ProcessState.ExitCode()))
+					}
+					channel.SendRequest("exit-status", false, status)
+				}()
+			})
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
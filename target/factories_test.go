@@ -0,0 +1,59 @@
+package target
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/willfantom/nescript"
+)
+
+func TestDockerFactoryRejectsContainerWithSelector(t *testing.T) {
+	for _, uri := range []string{
+		"docker://abc?name=router1",
+		"docker://abc?project=lab&service=router1",
+		"docker://abc?label=role=router",
+	} {
+		if _, err := Executor(uri); err == nil {
+			t.Errorf("expected '%s' to be rejected", uri)
+		}
+	}
+	if _, err := Executor("docker://"); err == nil {
+		t.Error("expected a target without a container or selector to be rejected")
+	}
+}
+
+func TestSSHFactoryClosesAgentConnection(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	executor, err := Executor("ssh://user@127.0.0.1:1?insecure=true")
+	if err != nil {
+		t.Fatalf("failed to create executor: %v", err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	if _, err := nescript.NewCmd("true").Exec(executor); err == nil {
+		t.Fatal("expected the connection to the target to fail")
+	}
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("agent connection was not closed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent was not connected to")
+	}
+}
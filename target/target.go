@@ -0,0 +1,127 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/willfantom/nescript"
+)
+
+// Factory creates an ExecFunc from a parsed target URI. The URI's scheme will
+// always be the scheme the factory was registered with.
+type Factory func(*url.URL) (nescript.ExecFunc, error)
+
+var (
+	factories     map[string]Factory = make(map[string]Factory)
+	factoriesLock sync.RWMutex
+)
+
+func init() {
+	Register("local", localFactory)
+	Register("ssh", sshFactory)
+	Register("docker", dockerFactory)
+}
+
+// Register makes a factory available for the given URI scheme. This is
+// intended to be called from the init function of packages providing an
+// executor. If a factory is already registered for the scheme, or the factory
+// is nil, this panics.
+func Register(scheme string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	scheme = strings.ToLower(scheme)
+	if factory == nil {
+		panic(fmt.Sprintf("target: factory for scheme '%s' is nil", scheme))
+	}
+	if _, exists := factories[scheme]; exists {
+		panic(fmt.Sprintf("target: factory for scheme '%s' already registered", scheme))
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns a sorted list of the schemes that have a registered factory.
+func Schemes() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Executor parses the given target URI and creates an ExecFunc using the
+// factory registered for the URI's scheme. For example:
+//   - local:///tmp/work
+//   - ssh://user@10.0.0.1:22/home/user
+//   - docker://container?workdir=/root
+//
+// This will error if the URI can not be parsed, no factory is registered for
+// its scheme, or the factory fails. Any password in the URI is masked in the
+// error.
+func Executor(uri string) (nescript.ExecFunc, error) {
+	targetURL, err := url.Parse(uri)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		redacted, password := redactUserinfo(uri)
+		if password != "" {
+			err = errors.New(strings.ReplaceAll(err.Error(), password, "xxxxx"))
+		}
+		return nil, fmt.Errorf("could not parse target uri '%s': %w", redacted, err)
+	}
+	if targetURL.Scheme == "" {
+		return nil, fmt.Errorf("target uri '%s' has no scheme", targetURL.Redacted())
+	}
+	factoriesLock.RLock()
+	factory, ok := factories[strings.ToLower(targetURL.Scheme)]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no executor registered for scheme '%s'", targetURL.Scheme)
+	}
+	executor, err := factory(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create executor for target '%s': %w", targetURL.Redacted(), err)
+	}
+	return executor, nil
+}
+
+// redactUserinfo returns the URI with the password of its userinfo (if any)
+// replaced with "xxxxx", as with url.URL.Redacted, along with the password.
+// This does not require the URI to be valid.
+func redactUserinfo(uri string) (string, string) {
+	authorityStart := 0
+	if idx := strings.Index(uri, "://"); idx >= 0 {
+		authorityStart = idx + 3
+	}
+	authority := uri[authorityStart:]
+	if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+		authority = authority[:end]
+	}
+	at := strings.LastIndex(authority, "@")
+	if at < 0 {
+		return uri, ""
+	}
+	user, password, ok := strings.Cut(authority[:at], ":")
+	if !ok || password == "" {
+		return uri, ""
+	}
+	return uri[:authorityStart] + user + ":xxxxx" + uri[authorityStart+at:], password
+}
+
+// MustExecutor creates an ExecFunc from the target URI, however will panic if
+// an error occurs.
+func MustExecutor(uri string) nescript.ExecFunc {
+	executor, err := Executor(uri)
+	if err != nil {
+		panic(err)
+	}
+	return executor
+}
@@ -0,0 +1,466 @@
+package nescript
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Escaping determines how values interpolated into a script/cmd by the
+// template engine are escaped.
+type Escaping int
+
+const (
+	// NoEscaping inserts values as-is.
+	NoEscaping Escaping = iota
+
+	// ShellEscaping escapes values for POSIX shells (sh, bash, etc...) based on
+	// where in the script they are inserted. Values inserted outside of quotes
+	// are single quoted, whilst values inserted within existing single, double
+	// or $'...' quotes, heredoc bodies or command substitutions are escaped for
+	// that context. Thus a value is always treated as a single literal word,
+	// no matter what characters it contains. Templates included via the
+	// template action or the include function are escaped for the context
+	// they are included in, and the shquote function does nothing (as values
+	// are already quoted). Values inserted into comments or heredoc bodies
+	// error if they could end the comment or heredoc. Where the context can not
+	// be determined reliably (e.g. within a backquoted command or parameter
+	// expansion, or directly after a $ or backslash), compilation fails rather
+	// than guessing.
+	ShellEscaping
+)
+
+const (
+	// includeFuncName is the name of the template function that renders a
+	// template from the same set (e.g. from a library) as a string.
+	includeFuncName string = "include"
+
+	// shquoteFuncName is the name of the template function that shell quotes
+	// a value, which does nothing with ShellEscaping.
+	shquoteFuncName string = "shquote"
+)
+
+var (
+	// shellEscapers maps each shell context to the name of the function
+	// appended to template actions to escape for the context. For heredoc
+	// contexts, this is the prefix of the name of a function for each action.
+	shellEscapers map[shellContext]string = map[shellContext]string{
+		shellBare:          "_nescript_shell_bare",
+		shellSingleQuoted:  "_nescript_shell_single",
+		shellDoubleQuoted:  "_nescript_shell_double",
+		shellANSIQuoted:    "_nescript_shell_ansi",
+		shellComment:       "_nescript_shell_comment",
+		shellHeredoc:       "_nescript_shell_heredoc",
+		shellQuotedHeredoc: "_nescript_shell_quoted_heredoc",
+	}
+)
+
+// templateOptions holds the configuration used when parsing and executing
+// the template of a script/cmd.
+type templateOptions struct {
+	escaping Escaping
+	funcs    template.FuncMap
+	strict   bool
+	library  *ScriptLibrary
+	inputs   map[string]InputSpec
+	err      error
+
+	// compiled is true once the script/cmd has been rendered, such that it is
+	// not validated or templated again.
+	compiled bool
+}
+
+// validate checks the given template data against the declared inputs.
+func (to templateOptions) validate(data map[string]any) error {
+	return ScriptMetadata{Inputs: to.inputs}.ValidateFields(data)
+}
+
+// withFuncs returns a copy of the options with the given functions added,
+// replacing any existing functions of the same name.
+func (to templateOptions) withFuncs(funcs template.FuncMap) templateOptions {
+	merged := make(template.FuncMap, len(to.funcs)+len(funcs))
+	for name, fn := range to.funcs {
+		merged[name] = fn
+	}
+	for name, fn := range funcs {
+		merged[name] = fn
+	}
+	to.funcs = merged
+	return to
+}
+
+// parse creates a template from the given text using the options.
+func (to templateOptions) parse(text string) (*template.Template, error) {
+	if to.err != nil {
+		return nil, to.err
+	}
+	funcs := DefaultFuncs()
+	if to.escaping == ShellEscaping {
+		funcs[shquoteFuncName] = fmt.Sprint
+	}
+	tmpl := template.New("").Funcs(funcs).Funcs(to.funcs)
+	tmpl = tmpl.Funcs(template.FuncMap{
+		includeFuncName: func(name string, data any) (string, error) {
+			included := &bytes.Buffer{}
+			if err := tmpl.ExecuteTemplate(included, name, data); err != nil {
+				return "", err
+			}
+			return included.String(), nil
+		},
+	})
+	if to.escaping == ShellEscaping {
+		funcs := make(template.FuncMap)
+		for sc, name := range shellEscapers {
+			if sc != shellHeredoc && sc != shellQuotedHeredoc {
+				funcs[name] = sc.escaper()
+			}
+		}
+		tmpl = tmpl.Funcs(funcs)
+	}
+	if to.strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	if _, err := tmpl.Parse(text); err != nil {
+		return nil, err
+	}
+	if to.library != nil {
+		if err := to.library.addTo(tmpl); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkTemplateCycles(tmpl); err != nil {
+		return nil, err
+	}
+	if to.escaping == ShellEscaping {
+		if err := newShellEscaper(tmpl).escape(); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// shellEscaper appends shell escaping functions to the actions of a set of
+// templates. As the context of a template depends on where it is included,
+// each template is escaped for the start of a script, and a copy of it is
+// escaped for every other position it is included at.
+type shellEscaper struct {
+	tmpl *template.Template
+
+	// trees are the unescaped trees of each template, escaped holds the names
+	// of the templates that have been escaped, and copies maps the name of a
+	// template and state to the name of the copy escaped for the state.
+	trees   map[string]*parse.Tree
+	escaped map[string]bool
+	copies  map[string]string
+
+	// heredocActions is the number of actions escaped in heredoc contexts,
+	// which each have their own escaping function.
+	heredocActions int
+}
+
+// newShellEscaper creates an escaper for the given set of templates.
+func newShellEscaper(tmpl *template.Template) *shellEscaper {
+	se := &shellEscaper{
+		tmpl:    tmpl,
+		trees:   make(map[string]*parse.Tree),
+		escaped: make(map[string]bool),
+		copies:  make(map[string]string),
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			se.trees[t.Name()] = t.Tree.Copy()
+		}
+	}
+	return se
+}
+
+// escape escapes every template for the start of a script.
+func (se *shellEscaper) escape() error {
+	names := make([]string, 0, len(se.trees))
+	for name := range se.trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := se.template(name, newShellState()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// template escapes the template with the given name for inclusion at the given
+// state, returning the name of the template to include. Templates that do not
+// exist are left to error when executed.
+func (se *shellEscaper) template(name string, state *shellState) (string, error) {
+	tree, ok := se.trees[name]
+	if !ok {
+		return name, nil
+	}
+	target := name
+	if key := state.key(); key != newShellState().key() {
+		copyKey := name + "\x00" + key
+		if _, ok := se.copies[copyKey]; !ok {
+			se.copies[copyKey] = fmt.Sprintf("%s$%d", name, len(se.copies)+1)
+		}
+		target = se.copies[copyKey]
+	}
+	if se.escaped[target] {
+		return target, nil
+	}
+	se.escaped[target] = true
+	tree = tree.Copy()
+	if _, err := se.tmpl.AddParseTree(target, tree); err != nil {
+		return "", err
+	}
+	return target, se.list(tree, tree.Root, state.clone())
+}
+
+// list walks the nodes of the list, appending the appropriate shell escaping
+// function to every action that outputs a value, and updating the state as it
+// goes. Branches of control structures are all assumed to start in the state
+// found before the control structure, and the state after them is taken from
+// their main branch. Included templates are escaped for the state they are
+// included at. This errors if an action is in a position that can not be
+// escaped reliably.
+func (se *shellEscaper) list(tree *parse.Tree, list *parse.ListNode, state *shellState) error {
+	if list == nil {
+		return nil
+	}
+	for idx, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			state.scan(string(n.Text))
+		case *parse.ActionNode:
+			if len(n.Pipe.Decl) != 0 {
+				continue
+			}
+			if isInclude(n.Pipe.Cmds[0]) {
+				if err := se.include(tree, n, state); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := se.action(tree, n, state, list.Nodes[idx+1:]); err != nil {
+				return err
+			}
+		case *parse.TemplateNode:
+			target, err := se.template(n.Name, state)
+			if err != nil {
+				return err
+			}
+			n.Name = target
+			state.included()
+		case *parse.IfNode:
+			if err := se.branch(tree, &n.BranchNode, state); err != nil {
+				return err
+			}
+		case *parse.RangeNode:
+			if err := se.branch(tree, &n.BranchNode, state); err != nil {
+				return err
+			}
+		case *parse.WithNode:
+			if err := se.branch(tree, &n.BranchNode, state); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// action appends the escaping function for the state to the action, given the
+// nodes that follow it.
+func (se *shellEscaper) action(tree *parse.Tree, n *parse.ActionNode, state *shellState, next []parse.Node) error {
+	sc, doc, err := state.context()
+	if err != nil {
+		location, _ := tree.ErrorContext(n)
+		return fmt.Errorf("%s: action can not be shell escaped, as %w", location, err)
+	}
+	name := shellEscapers[sc]
+	if sc == shellHeredoc || sc == shellQuotedHeredoc {
+		suffix, suffixKnown := "", false
+		if len(next) != 0 {
+			if text, ok := next[0].(*parse.TextNode); ok {
+				suffix = string(text.Text)
+				if end := strings.IndexByte(suffix, '\n'); end >= 0 {
+					suffix, suffixKnown = suffix[:end], true
+				}
+			}
+		}
+		se.heredocActions++
+		name = fmt.Sprintf("%s_%d", name, se.heredocActions)
+		se.tmpl.Funcs(template.FuncMap{name: doc.escaper(state.heredocLine(suffix, suffixKnown))})
+	}
+	n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      n.Pos,
+		Args:     []parse.Node{parse.NewIdentifier(name).SetTree(nil).SetPos(n.Pos)},
+	})
+	state.action()
+	return nil
+}
+
+// include escapes the template included by the action for the state. This
+// errors if the name of the template is not a constant, unless the state is
+// that of the start of a script.
+func (se *shellEscaper) include(tree *parse.Tree, n *parse.ActionNode, state *shellState) error {
+	name, ok := includeName(n.Pipe.Cmds[0])
+	if !ok {
+		if state.key() != newShellState().key() {
+			location, _ := tree.ErrorContext(n)
+			return fmt.Errorf("%s: include can not be shell escaped, as the template name is not a constant", location)
+		}
+		return nil
+	}
+	target, err := se.template(name, state)
+	if err != nil {
+		return err
+	}
+	arg := n.Pipe.Cmds[0].Args[1].(*parse.StringNode)
+	arg.Text, arg.Quoted = target, strconv.Quote(target)
+	state.included()
+	return nil
+}
+
+// branch escapes both lists of a control structure.
+func (se *shellEscaper) branch(tree *parse.Tree, branch *parse.BranchNode, state *shellState) error {
+	if err := se.list(tree, branch.ElseList, state.clone()); err != nil {
+		return err
+	}
+	return se.list(tree, branch.List, state)
+}
+
+// fields parses each of the given texts and returns the sorted, unique paths
+// (e.g. "Name" or "node.addr") of every field referenced relative to the top
+// level template data.
+func (to templateOptions) fields(texts ...string) ([]string, error) {
+	if to.compiled {
+		return []string{}, nil
+	}
+	walker := fieldWalker{
+		fields:  make(map[string]bool),
+		visited: make(map[string]bool),
+	}
+	for _, text := range texts {
+		tmpl, err := to.parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		walker.tmpl = tmpl
+		walker.visited = make(map[string]bool)
+		walker.template(tmpl.Name())
+	}
+	fields := make([]string, 0, len(walker.fields))
+	for field := range walker.fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// fieldWalker collects the fields referenced by a parsed template.
+type fieldWalker struct {
+	tmpl    *template.Template
+	fields  map[string]bool
+	visited map[string]bool
+}
+
+// template walks the associated template of the given name, assuming it is
+// executed with the top level data.
+func (fw *fieldWalker) template(name string) {
+	if fw.visited[name] {
+		return
+	}
+	fw.visited[name] = true
+	if t := fw.tmpl.Lookup(name); t != nil && t.Tree != nil {
+		fw.node(t.Tree.Root, true)
+	}
+}
+
+// node walks the given node, where top is true if dot is the top level data.
+// Fields relative to dot are only collected when dot is the top level data,
+// whereas fields relative to $ are always collected.
+func (fw *fieldWalker) node(node parse.Node, top bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			fw.node(child, top)
+		}
+	case *parse.ActionNode:
+		fw.node(n.Pipe, top)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			fw.node(cmd, top)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			fw.node(arg, top)
+		}
+		if name, ok := includeName(n); ok && top && len(n.Args) == 3 {
+			if _, isDot := n.Args[2].(*parse.DotNode); isDot {
+				fw.template(name)
+			}
+		}
+	case *parse.ChainNode:
+		switch base := unwrapPipe(n.Node).(type) {
+		case *parse.FieldNode:
+			chained := *base
+			chained.Ident = append(append([]string{}, base.Ident...), n.Field...)
+			fw.node(&chained, top)
+		case *parse.VariableNode:
+			chained := *base
+			chained.Ident = append(append([]string{}, base.Ident...), n.Field...)
+			fw.node(&chained, top)
+		default:
+			fw.node(n.Node, top)
+		}
+	case *parse.FieldNode:
+		if top {
+			fw.fields[strings.Join(n.Ident, ".")] = true
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			fw.fields[strings.Join(n.Ident[1:], ".")] = true
+		}
+	case *parse.IfNode:
+		fw.node(n.Pipe, top)
+		fw.node(n.List, top)
+		fw.node(n.ElseList, top)
+	case *parse.RangeNode:
+		fw.node(n.Pipe, top)
+		fw.node(n.List, false)
+		fw.node(n.ElseList, top)
+	case *parse.WithNode:
+		fw.node(n.Pipe, top)
+		fw.node(n.List, false)
+		fw.node(n.ElseList, top)
+	case *parse.TemplateNode:
+		fw.node(n.Pipe, top)
+		if top && n.Pipe != nil && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			if _, isDot := n.Pipe.Cmds[0].Args[0].(*parse.DotNode); isDot {
+				fw.template(n.Name)
+			}
+		}
+	}
+}
+
+// unwrapPipe returns the single argument of the node if it is a parenthesised
+// pipeline of one argument (e.g. the (.node) of (.node).addr), otherwise the
+// node itself.
+func unwrapPipe(node parse.Node) parse.Node {
+	pipe, ok := node.(*parse.PipeNode)
+	if !ok || len(pipe.Decl) != 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return node
+	}
+	return unwrapPipe(pipe.Cmds[0].Args[0])
+}
@@ -0,0 +1,104 @@
+package nescript
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScriptFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"none", "echo hi", []string{}},
+		{"top level", "echo {{.b}} {{.a}} {{.b}}", []string{"a", "b"}},
+		{"nested", "ping {{.node.addr}} -c {{.count}}", []string{"count", "node.addr"}},
+		{"chain", "echo {{(.node).addr}}", []string{"node.addr"}},
+		{"root chain", "{{range .x}}{{($.node).addr}}{{end}}", []string{"node.addr", "x"}},
+		{"pipeline", `echo {{.port | default 22}}`, []string{"port"}},
+		{"if", "{{if .debug}}set -x{{else}}{{.quiet}}{{end}}", []string{"debug", "quiet"}},
+		{"range", "{{range .hosts}}{{.addr}}{{end}}", []string{"hosts"}},
+		{"range root", "{{range .hosts}}{{.}} {{$.user}}{{end}}", []string{"hosts", "user"}},
+		{"range nested root", "{{range .hosts}}{{$.node.addr}}{{end}}", []string{"hosts", "node.addr"}},
+		{"range else", "{{range .hosts}}{{.addr}}{{else}}{{.fallback}}{{end}}", []string{"fallback", "hosts"}},
+		{"with", "{{with .node}}{{.addr}} {{$.port}}{{end}}", []string{"node", "port"}},
+		{"variable", "{{$n := .node}}{{$n.addr}}", []string{"node"}},
+		{"defined template", `{{define "t"}}{{.inner}}{{end}}{{template "t" .}}`, []string{"inner"}},
+		{"defined template with other data", `{{define "t"}}{{.inner}}{{end}}{{template "t" .node}}`, []string{"node"}},
+		{"include", `{{define "t"}}{{.inner}}{{end}}{{include "t" . | shquote}}`, []string{"inner"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := NewScript(test.text).Fields()
+			if err != nil {
+				t.Fatalf("failed to get fields: %v", err)
+			}
+			if !reflect.DeepEqual(fields, test.expected) {
+				t.Errorf("expected fields %v, got %v", test.expected, fields)
+			}
+		})
+	}
+}
+
+func TestScriptFieldsParseError(t *testing.T) {
+	if _, err := NewScript("echo {{.a").Fields(); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestCmdFields(t *testing.T) {
+	fields, err := NewCmd("ping", "{{.node.addr}}", "-c", "{{.count}}", "{{range .x}}{{$.count}}{{end}}").Fields()
+	if err != nil {
+		t.Fatalf("failed to get fields: %v", err)
+	}
+	if expected := []string{"count", "node.addr", "x"}; !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected fields %v, got %v", expected, fields)
+	}
+}
+
+func TestStrictMissingFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		fields  map[string]any
+		missing string
+	}{
+		{"top level", "echo {{.a}}", nil, "a"},
+		{"nested", "echo {{.node.addr}}", map[string]any{"node": map[string]any{"name": "n"}}, "addr"},
+		{"root within range", "{{range .hosts}}{{$.user}}{{end}}", map[string]any{"hosts": []int{1}}, "user"},
+		{"defined template", `{{define "t"}}{{.inner}}{{end}}{{template "t" .}}`, nil, "inner"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := *NewScript(test.text)
+			for key, value := range test.fields {
+				script = script.WithField(key, value)
+			}
+			if _, err := script.Compile(); err != nil {
+				t.Fatalf("expected non-strict compile to succeed: %v", err)
+			}
+			_, err := script.WithStrict(true).Compile()
+			if err == nil {
+				t.Fatal("expected strict compile to fail")
+			}
+			if !strings.Contains(err.Error(), test.missing) {
+				t.Errorf("expected error to name %q, got: %v", test.missing, err)
+			}
+		})
+	}
+}
+
+func TestStrictCmd(t *testing.T) {
+	if _, err := NewCmd("echo", "{{.a}}").WithStrict(true).Compile(); err == nil {
+		t.Error("expected strict compile to fail")
+	}
+	compiled, err := NewCmd("echo", "{{.a}}").WithStrict(true).WithField("a", "b").Compile()
+	if err != nil {
+		t.Fatalf("failed to compile: %v", err)
+	}
+	if compiled.Raw()[1] != "b" {
+		t.Errorf("expected arg b, got %q", compiled.Raw()[1])
+	}
+}
@@ -0,0 +1,82 @@
+package nescript
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// usageFormat is the GNU time format used to report usage, where the
+	// reported values are: user time (s), system time (s), max RSS (KB),
+	// voluntary context switches and involuntary context switches.
+	usageFormat string = "::nescript-usage::%U,%S,%M,%w,%c"
+)
+
+var (
+	// usageLineRegex matches the line written by GNU time, optionally preceded
+	// by the line it writes when the command did not exit cleanly.
+	usageLineRegex *regexp.Regexp = regexp.MustCompile(`(?m)(?:^Command (?:exited with non-zero status|terminated by signal) \d+\n)?^::nescript-usage::([0-9.]+),([0-9.]+),(\d+),(\d+),(\d+)\n?`)
+)
+
+// UsageMiddleware runs every cmd under GNU time (at the given path, or
+// /usr/bin/time if empty) so that the resources used by the process can be
+// collected on targets where the executor can not do so itself, such as SSH
+// and docker targets. The usage is removed from the stderr of the result and
+// set as the result's Usage. The target must have GNU time installed (other
+// implementations, such as that of busybox, do not support the required
+// format option). If time does not report the usage (e.g. it was killed along
+// with the cmd), the result is returned as is, with a nil Usage.
+func UsageMiddleware(timePath string) Middleware {
+	if timePath == "" {
+		timePath = "/usr/bin/time"
+	}
+	return func(next ExecFunc) ExecFunc {
+		return func(c Cmd) (Process, error) {
+			process, err := next(c.WithPrefix(timePath, "-f", usageFormat, "--"))
+			if err != nil {
+				return nil, err
+			}
+			return &hookedProcess{
+				Process: process,
+				onResult: func(r *Result, err error) (*Result, error) {
+					if err != nil {
+						return r, err
+					}
+					r.Usage, r.StdErr = parseUsage(r.StdErr)
+					return r, nil
+				},
+			}, nil
+		}
+	}
+}
+
+// parseUsage extracts the last usage line written by GNU time from stderr,
+// returning the usage and stderr with the usage line removed. If there is no
+// such line, the usage is nil.
+func parseUsage(stderr string) (*Usage, string) {
+	locations := usageLineRegex.FindAllStringSubmatchIndex(stderr, -1)
+	if len(locations) == 0 {
+		return nil, stderr
+	}
+	location := locations[len(locations)-1]
+	field := func(idx int) string {
+		return stderr[location[2*idx]:location[2*idx+1]]
+	}
+	seconds := func(idx int) time.Duration {
+		value, _ := strconv.ParseFloat(field(idx), 64)
+		return time.Duration(value * float64(time.Second))
+	}
+	integer := func(idx int) int64 {
+		value, _ := strconv.ParseInt(field(idx), 10, 64)
+		return value
+	}
+	usage := Usage{
+		UserTime:                   seconds(1),
+		SystemTime:                 seconds(2),
+		MaxRSS:                     integer(3) * 1024,
+		VoluntaryContextSwitches:   integer(4),
+		InvoluntaryContextSwitches: integer(5),
+	}
+	return &usage, stderr[:location[0]] + stderr[location[1]:]
+}
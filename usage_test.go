@@ -0,0 +1,52 @@
+package nescript
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsageMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		usage  *Usage
+		output string
+	}{
+		{
+			name:   "reported",
+			stderr: "warning\nCommand exited with non-zero status 3\n::nescript-usage::0.50,0.25,2048,4,5\n",
+			usage:  &Usage{UserTime: 500 * time.Millisecond, SystemTime: 250 * time.Millisecond, MaxRSS: 2048 * 1024, VoluntaryContextSwitches: 4, InvoluntaryContextSwitches: 5},
+			output: "warning\n",
+		},
+		{
+			name:   "not reported",
+			stderr: "Command terminated by signal 9\n",
+			output: "Command terminated by signal 9\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmds := make([]Cmd, 0)
+			executor := UsageMiddleware("")(fakeExecutor(&cmds, func(Cmd) (Process, error) {
+				return &fakeProcess{result: &Result{StdOut: "out", StdErr: test.stderr, ExitCode: 3}}, nil
+			}))
+			process, err := NewCmd("true").Exec(executor)
+			if err != nil {
+				t.Fatalf("failed to exec: %v", err)
+			}
+			result, err := process.Result()
+			if err != nil {
+				t.Fatalf("failed to get result: %v", err)
+			}
+			if result.StdOut != "out" || result.StdErr != test.output || result.ExitCode != 3 {
+				t.Errorf("unexpected result: %+v", result)
+			}
+			if (test.usage == nil) != (result.Usage == nil) || (test.usage != nil && *test.usage != *result.Usage) {
+				t.Errorf("usage is %+v, expected %+v", result.Usage, test.usage)
+			}
+			if argv := cmds[0].Raw(); argv[0] != "/usr/bin/time" || argv[len(argv)-1] != "true" {
+				t.Errorf("cmd was not run under time: %v", argv)
+			}
+		})
+	}
+}
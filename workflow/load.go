@@ -0,0 +1,465 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/willfantom/nescript"
+	"github.com/willfantom/nescript/target"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader creates workflows from YAML workflow files. A workflow file describes
+// the targets that steps run on (as target URIs, see the target package), the
+// scripts that steps can use, fields and env vars given to every step, and the
+// steps themselves. For example:
+//
+//	target: local
+//	targets:
+//	  local: local://
+//	  router: ssh://user@10.0.0.1?identity=/home/me/.ssh/id_ed25519
+//	scripts:
+//	  setup: {file: scripts/setup.sh}
+//	  probe: {url: "https://example.com/probe.sh", sha256: "..."}
+//	  hello: {inline: "echo hello {{.name}}", subcommand: [bash, -c]}
+//	fields:
+//	  name: world
+//	  iface: eth0
+//	env: [LANG=C]
+//	teardown-timeout: 1m
+//	steps:
+//	  - name: setup
+//	    script: setup
+//	    target: router
+//	    timeout: 30s
+//	    teardown:
+//	      cmd: tc qdisc del dev {{.iface}} root
+//	      timeout: 10s
+//	  - name: capture
+//	    run: tcpdump -w /tmp/ping.pcap & echo "::set-output name=pid::$!"
+//	    teardown: kill {{.steps.capture.outputs.pid}}
+//	  - name: ping
+//	    cmd: ping -c 3 {{.steps.setup.outputs.addr}}
+//	    if: steps.setup.outputs.ready == "yes"
+//	  - name: latency
+//	    run: echo "::set-output name=latency type=int::42"
+//	    assert: latency < 100
+//	    continue-on-error: true
+//
+// Each step uses exactly one of script (a script named in scripts), run (an
+// inline script) or cmd (a command string, see nescript.NewCmdFromString, or a
+// list of args). A step's target defaults to the file's target. Steps can also
+// have their own fields and env, which take precedence over those of the file.
+// A step can have a teardown (see Teardown), given either as an inline script
+// or as a mapping with one of script, run or cmd, and optionally a target and
+// timeout. A teardown has the same fields and env as its step, and runs on the
+// step's target unless it has its own.
+type Loader struct {
+	// FS is the file system that file scripts are read from (see
+	// nescript.NewScriptFromFS). If nil, file scripts can not be used.
+	FS fs.FS
+
+	// Fetcher is used to fetch URL scripts.
+	Fetcher nescript.ScriptFetcher
+}
+
+// fileSpec is the schema of a workflow file.
+type fileSpec struct {
+	Target  string                `yaml:"target"`
+	Targets map[string]string     `yaml:"targets"`
+	Scripts map[string]scriptSpec `yaml:"scripts"`
+	Fields  map[string]any        `yaml:"fields"`
+	Env     []string              `yaml:"env"`
+	Steps   []stepSpec            `yaml:"steps"`
+
+	TeardownTimeout time.Duration `yaml:"teardown-timeout"`
+}
+
+// scriptSpec is the schema of a script in a workflow file.
+type scriptSpec struct {
+	Inline     string   `yaml:"inline"`
+	File       string   `yaml:"file"`
+	URL        string   `yaml:"url"`
+	SHA256     string   `yaml:"sha256"`
+	Subcommand []string `yaml:"subcommand"`
+}
+
+// stepSpec is the schema of a step in a workflow file.
+type stepSpec struct {
+	Name            string         `yaml:"name"`
+	Script          string         `yaml:"script"`
+	Run             string         `yaml:"run"`
+	Cmd             commandSpec    `yaml:"cmd"`
+	Target          string         `yaml:"target"`
+	Fields          map[string]any `yaml:"fields"`
+	Env             []string       `yaml:"env"`
+	If              string         `yaml:"if"`
+	Assert          stringList     `yaml:"assert"`
+	Timeout         time.Duration  `yaml:"timeout"`
+	ContinueOnError bool           `yaml:"continue-on-error"`
+	Teardown        *teardownSpec  `yaml:"teardown"`
+}
+
+// teardownSpec is the schema of a step's teardown in a workflow file, which
+// can also be given as a single string (an inline script).
+type teardownSpec struct {
+	Script  string        `yaml:"script"`
+	Run     string        `yaml:"run"`
+	Cmd     commandSpec   `yaml:"cmd"`
+	Target  string        `yaml:"target"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// commandSpec is a cmd given either as a command string or a list of args.
+type commandSpec struct {
+	text string
+	argv []string
+}
+
+// stringList is a list of strings that can also be given as a single string.
+type stringList []string
+
+// LoadFile creates a workflow from the workflow file at the given path. File
+// scripts are read relative to the directory containing the workflow file.
+func LoadFile(ctx context.Context, path string) (*Workflow, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow file: %w", err)
+	}
+	loader := Loader{
+		FS: os.DirFS(filepath.Dir(path)),
+	}
+	return loader.Load(ctx, raw)
+}
+
+// Load creates a workflow from the given workflow file content. Every step's
+// executor is created from its target, and every script is loaded, so that
+// the returned workflow is ready to run. This errors if the file does not
+// match the schema (or refers to unknown targets or scripts), where the error
+// gives the line of the file at fault, or if a target or script can not be
+// loaded.
+func (l Loader) Load(ctx context.Context, raw []byte) (*Workflow, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse workflow file: %w", err)
+	}
+	var spec fileSpec
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid workflow file: %w", err)
+	}
+	positions := positions{root: &root}
+	executors := make(map[string]nescript.ExecFunc)
+	targetNames := make([]string, 0, len(spec.Targets))
+	for name := range spec.Targets {
+		targetNames = append(targetNames, name)
+	}
+	sort.Strings(targetNames)
+	for _, name := range targetNames {
+		executor, err := target.Executor(spec.Targets[name])
+		if err != nil {
+			return nil, lineErrorf(positions.line("targets", name), "failed to create target '%s': %w", name, err)
+		}
+		executors[name] = executor
+	}
+	if spec.Target != "" && executors[spec.Target] == nil {
+		return nil, lineErrorf(positions.line("target"), "unknown target '%s'", spec.Target)
+	}
+	scriptNames := make([]string, 0, len(spec.Scripts))
+	for name := range spec.Scripts {
+		scriptNames = append(scriptNames, name)
+	}
+	sort.Strings(scriptNames)
+	for _, name := range scriptNames {
+		if err := spec.Scripts[name].validate(); err != nil {
+			return nil, lineErrorf(positions.line("scripts", name), "invalid script '%s': %w", name, err)
+		}
+	}
+	for idx, e := range spec.Env {
+		if !isEnvVar(e) {
+			return nil, lineErrorf(positions.line("env", idx), "env var '%s' is not in KEY=VALUE format", e)
+		}
+	}
+	if _, ok := spec.Fields[stepsField]; ok {
+		return nil, lineErrorf(positions.line("fields", stepsField), "the field '%s' is reserved", stepsField)
+	}
+	workflow := NewWorkflow().WithFields(spec.Fields).WithTeardownTimeout(spec.TeardownTimeout)
+	names := make(map[string]bool)
+	fetched := make(map[string]*nescript.Script)
+	for idx, stepSpec := range spec.Steps {
+		if !stepNameRegex.MatchString(stepSpec.Name) {
+			return nil, lineErrorf(positions.line("steps", idx, "name"), "step name '%s' is invalid", stepSpec.Name)
+		}
+		if names[stepSpec.Name] {
+			return nil, lineErrorf(positions.line("steps", idx, "name"), "multiple steps named '%s'", stepSpec.Name)
+		}
+		names[stepSpec.Name] = true
+		step, err := l.step(ctx, spec, stepSpec, executors, fetched)
+		if err != nil {
+			return nil, lineErrorf(positions.line("steps", idx), "invalid step '%s': %w", stepSpec.Name, err)
+		}
+		workflow = workflow.WithStep(step)
+	}
+	if err := workflow.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid workflow file: %w", err)
+	}
+	return &workflow, nil
+}
+
+// step creates a step from its spec.
+func (l Loader) step(ctx context.Context, spec fileSpec, stepSpec stepSpec, executors map[string]nescript.ExecFunc, fetched map[string]*nescript.Script) (Step, error) {
+	step := Step{
+		Name:            stepSpec.Name,
+		If:              stepSpec.If,
+		Assert:          stepSpec.Assert,
+		Timeout:         stepSpec.Timeout,
+		ContinueOnError: stepSpec.ContinueOnError,
+	}
+	var err error
+	if step.Executor, err = stepExecutor(spec, stepSpec.Target, executors); err != nil {
+		return step, err
+	}
+	for _, e := range stepSpec.Env {
+		if !isEnvVar(e) {
+			return step, fmt.Errorf("env var '%s' is not in KEY=VALUE format", e)
+		}
+	}
+	env := append(append([]string{}, spec.Env...), stepSpec.Env...)
+	step.Script, step.Cmd, err = l.command(ctx, spec, stepSpec.Script, stepSpec.Run, stepSpec.Cmd, stepSpec.Fields, env, fetched)
+	if err != nil {
+		return step, err
+	}
+	if stepSpec.Teardown != nil {
+		teardown := Teardown{
+			Timeout: stepSpec.Teardown.Timeout,
+		}
+		if stepSpec.Teardown.Target != "" {
+			if teardown.Executor, err = stepExecutor(spec, stepSpec.Teardown.Target, executors); err != nil {
+				return step, fmt.Errorf("invalid teardown: %w", err)
+			}
+		}
+		teardown.Script, teardown.Cmd, err = l.command(ctx, spec, stepSpec.Teardown.Script, stepSpec.Teardown.Run, stepSpec.Teardown.Cmd, stepSpec.Fields, env, fetched)
+		if err != nil {
+			return step, fmt.Errorf("invalid teardown: %w", err)
+		}
+		step.Teardown = &teardown
+	}
+	return step, nil
+}
+
+// stepExecutor returns the executor of the named target, falling back to the
+// file's target if no name is given.
+func stepExecutor(spec fileSpec, targetName string, executors map[string]nescript.ExecFunc) (nescript.ExecFunc, error) {
+	if targetName == "" {
+		targetName = spec.Target
+	}
+	if targetName == "" {
+		return nil, fmt.Errorf("no target was given")
+	}
+	executor := executors[targetName]
+	if executor == nil {
+		return nil, fmt.Errorf("unknown target '%s'", targetName)
+	}
+	return executor, nil
+}
+
+// command creates the script or cmd of a step or teardown from whichever of
+// script (a script named in the file's scripts), run or cmd is given, adding
+// the given fields and env.
+func (l Loader) command(ctx context.Context, spec fileSpec, scriptName, run string, cmdSpec commandSpec, fields map[string]any, env []string, fetched map[string]*nescript.Script) (*nescript.Script, *nescript.Cmd, error) {
+	kinds := 0
+	for _, set := range []bool{scriptName != "", run != "", !cmdSpec.isEmpty()} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, nil, fmt.Errorf("exactly one of script, run or cmd must be given")
+	}
+	switch {
+	case scriptName != "":
+		scriptSpec, ok := spec.Scripts[scriptName]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown script '%s'", scriptName)
+		}
+		script, err := l.script(ctx, scriptSpec, fetched)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load script '%s': %w", scriptName, err)
+		}
+		withData := script.WithFields(fields, true).WithEnv(env...)
+		return &withData, nil, nil
+	case run != "":
+		script, err := nescript.ParseScript(run)
+		if err != nil {
+			return nil, nil, err
+		}
+		withData := script.WithFields(fields, true).WithEnv(env...)
+		return &withData, nil, nil
+	default:
+		cmd, err := cmdSpec.cmd()
+		if err != nil {
+			return nil, nil, err
+		}
+		withData := cmd.WithFields(fields, true).WithEnv(env...)
+		return nil, &withData, nil
+	}
+}
+
+// script loads a new instance of the script from its spec, such that each
+// step has its own data. Scripts fetched from URLs are only fetched once.
+func (l Loader) script(ctx context.Context, spec scriptSpec, fetched map[string]*nescript.Script) (*nescript.Script, error) {
+	var script *nescript.Script
+	var err error
+	switch {
+	case spec.Inline != "":
+		script, err = nescript.ParseScript(spec.Inline)
+	case spec.File != "":
+		if l.FS == nil {
+			return nil, fmt.Errorf("file scripts can not be used without a file system")
+		}
+		script, err = nescript.NewScriptFromFS(l.FS, spec.File)
+	default:
+		if _, ok := fetched[spec.URL]; !ok {
+			if fetched[spec.URL], err = l.Fetcher.Fetch(ctx, spec.URL, spec.SHA256); err != nil {
+				return nil, err
+			}
+		}
+		script, err = nescript.ParseScript(fetched[spec.URL].Raw())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if spec.Subcommand != nil {
+		withSubcommand := script.WithSubcommand(spec.Subcommand)
+		script = &withSubcommand
+	}
+	return script, nil
+}
+
+// validate checks that exactly one source is given for the script.
+func (ss scriptSpec) validate() error {
+	sources := 0
+	for _, source := range []string{ss.Inline, ss.File, ss.URL} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of inline, file or url must be given")
+	}
+	if ss.SHA256 != "" && ss.URL == "" {
+		return fmt.Errorf("sha256 can only be given for url scripts")
+	}
+	return nil
+}
+
+func (cs *commandSpec) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&cs.text)
+	case yaml.SequenceNode:
+		return node.Decode(&cs.argv)
+	}
+	return fmt.Errorf("line %d: cmd must be a string or a list of strings", node.Line)
+}
+
+func (ts *teardownSpec) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Decode(&ts.Run)
+	case yaml.MappingNode:
+		known := map[string]bool{"script": true, "run": true, "cmd": true, "target": true, "timeout": true}
+		for idx := 0; idx < len(node.Content); idx += 2 {
+			if key := node.Content[idx]; !known[key.Value] {
+				return fmt.Errorf("line %d: field %s not found in teardown", key.Line, key.Value)
+			}
+		}
+		type plain teardownSpec
+		return node.Decode((*plain)(ts))
+	}
+	return fmt.Errorf("line %d: teardown must be a string or a mapping", node.Line)
+}
+
+func (cs commandSpec) isEmpty() bool {
+	return cs.text == "" && len(cs.argv) == 0
+}
+
+// cmd creates the cmd from its spec.
+func (cs commandSpec) cmd() (*nescript.Cmd, error) {
+	if cs.text != "" {
+		return nescript.NewCmdFromString(cs.text)
+	}
+	return nescript.NewCmd(cs.argv[0], cs.argv[1:]...), nil
+}
+
+func (sl *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*sl = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*sl = list
+	return nil
+}
+
+// positions finds the lines of values within a parsed YAML document.
+type positions struct {
+	root *yaml.Node
+}
+
+// line returns the line of the value at the path (made up of map keys and
+// list indexes), or the line of the closest parent value that exists.
+func (p positions) line(path ...any) int {
+	node := p.root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, element := range path {
+		next := (*yaml.Node)(nil)
+		switch key := element.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for idx := 0; idx+1 < len(node.Content); idx += 2 {
+					if node.Content[idx].Value == key {
+						next = node.Content[idx+1]
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && key < len(node.Content) {
+				next = node.Content[key]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return node.Line
+}
+
+// lineErrorf creates an error for the given line of a workflow file.
+func lineErrorf(line int, format string, args ...any) error {
+	return fmt.Errorf("invalid workflow file: line %d: %s", line, fmt.Errorf(format, args...))
+}
+
+func isEnvVar(e string) bool {
+	for idx, r := range e {
+		if r == '=' {
+			return idx > 0
+		}
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package workflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// loadTestFS holds the file scripts available to loaded workflow files.
+var loadTestFS fstest.MapFS = fstest.MapFS{
+	"scripts/greet.sh": {Data: []byte(`echo "{{.greeting}} {{.name}} $GREETER"`)},
+}
+
+// load loads the workflow file, failing the test if it is invalid.
+func load(t *testing.T, raw string) *Workflow {
+	t.Helper()
+	workflow, err := (Loader{FS: loadTestFS}).Load(context.Background(), []byte(raw))
+	if err != nil {
+		t.Fatalf("failed to load workflow: %v", err)
+	}
+	return workflow
+}
+
+// loadAndRun loads the workflow file and runs it, failing the test if either
+// errors.
+func loadAndRun(t *testing.T, raw string) *Result {
+	t.Helper()
+	result, err := load(t, raw).Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run workflow: %v", err)
+	}
+	return result
+}
+
+func TestLoadCommandKinds(t *testing.T) {
+	tests := []struct {
+		name   string
+		step   string
+		stdout string
+		err    string
+	}{
+		{"script", "script: greet", "hi file env\n", ""},
+		{"run", `run: echo "run {{.name}}"`, "run file\n", ""},
+		{"cmd string", `cmd: echo "cmd {{.name}}"`, "cmd file\n", ""},
+		{"cmd list", `cmd: [echo, "cmd {{.name}}"]`, "cmd file\n", ""},
+		{"none", "if: true", "", "exactly one of script, run or cmd"},
+		{"script and run", "{script: greet, run: echo}", "", "exactly one of script, run or cmd"},
+		{"script and cmd", "{script: greet, cmd: echo}", "", "exactly one of script, run or cmd"},
+		{"run and cmd", "{run: echo, cmd: echo}", "", "exactly one of script, run or cmd"},
+		{"all", "{script: greet, run: echo, cmd: echo}", "", "exactly one of script, run or cmd"},
+		{"unknown script", "script: missing", "", "unknown script 'missing'"},
+		{"invalid cmd string", "cmd: echo a | wc", "", "unsupported shell operator"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step := "    " + test.step
+			if strings.HasPrefix(test.step, "{") {
+				step = "    " + strings.Trim(strings.ReplaceAll(test.step, ", ", "\n    "), "{}")
+			}
+			raw := strings.Join([]string{
+				"target: local",
+				"targets: {local: 'local://'}",
+				"scripts: {greet: {file: scripts/greet.sh}}",
+				"fields: {greeting: hi, name: file}",
+				"env: [GREETER=env]",
+				"steps:",
+				"  - name: step",
+				step,
+			}, "\n")
+			workflow, err := (Loader{FS: loadTestFS}).Load(context.Background(), []byte(raw))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing %q, got: %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to load workflow: %v", err)
+			}
+			result, err := workflow.Run(context.Background())
+			if err != nil {
+				t.Fatalf("failed to run workflow: %v", err)
+			}
+			if stdout := result.Steps[0].Result.StdOut; stdout != test.stdout {
+				t.Errorf("expected stdout %q, got %q", test.stdout, stdout)
+			}
+		})
+	}
+}
+
+func TestLoadStepOverrides(t *testing.T) {
+	result := loadAndRun(t, `
+target: local
+targets:
+  local: local://
+scripts:
+  greet: {file: scripts/greet.sh}
+fields: {greeting: hi, name: file}
+env: [GREETER=file, LANG=C]
+steps:
+  - name: defaults
+    script: greet
+  - name: overridden
+    script: greet
+    fields: {name: step}
+    env: [GREETER=step]
+  - name: run
+    run: echo "{{.greeting}} {{.name}} $GREETER $LANG"
+    fields: {greeting: hello}
+    env: [LANG=POSIX]
+  - name: cmd
+    cmd: [env]
+    env: [GREETER=cmd]
+`)
+	expected := map[string]string{
+		"defaults":   "hi file file\n",
+		"overridden": "hi step step\n",
+		"run":        "hello file file POSIX\n",
+	}
+	for name, stdout := range expected {
+		if got := stepResult(t, result, name).Result.StdOut; got != stdout {
+			t.Errorf("expected step '%s' stdout %q, got %q", name, stdout, got)
+		}
+	}
+	if env := stepResult(t, result, "cmd").Result.StdOut; !strings.Contains(env, "GREETER=cmd\n") || strings.Contains(env, "GREETER=file\n") {
+		t.Errorf("expected the step's env to take precedence, got:\n%s", env)
+	}
+}
+
+func TestLoadTeardowns(t *testing.T) {
+	tests := []struct {
+		name     string
+		teardown string
+		stdout   string
+	}{
+		{"inline script", `teardown: echo "undo {{.steps.step.outputs.pid}}"`, "undo 123\n"},
+		{"run", "teardown:\n      run: echo \"undo {{.name}}\"", "undo file\n"},
+		{"cmd string", "teardown:\n      cmd: echo undo {{.steps.step.status}}", "undo success\n"},
+		{"cmd list", "teardown:\n      cmd: [echo, undo, '{{.name}}']", "undo file\n"},
+		{"script", "teardown:\n      script: greet", "hi file env\n"},
+		{"target and timeout", "teardown:\n      run: pwd\n      target: tmp\n      timeout: 10s", "/tmp\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := loadAndRun(t, strings.Join([]string{
+				"target: local",
+				"targets: {local: 'local://', tmp: 'local:///tmp'}",
+				"scripts: {greet: {file: scripts/greet.sh}}",
+				"fields: {greeting: hi, name: file}",
+				"env: [GREETER=env]",
+				"steps:",
+				"  - name: step",
+				`    run: echo "::set-output name=pid::123"`,
+				"    " + test.teardown,
+			}, "\n"))
+			if len(result.Teardowns) != 1 {
+				t.Fatalf("expected one teardown, got %+v", result.Teardowns)
+			}
+			teardown := result.Teardowns[0]
+			if teardown.Err != nil {
+				t.Fatalf("teardown failed: %v", teardown.Err)
+			}
+			if teardown.Result.StdOut != test.stdout {
+				t.Errorf("expected stdout %q, got %q", test.stdout, teardown.Result.StdOut)
+			}
+		})
+	}
+}
+
+func TestLoadTeardownTimeout(t *testing.T) {
+	workflow := load(t, `
+target: local
+targets: {local: 'local://'}
+teardown-timeout: 1m
+steps:
+  - name: step
+    run: "true"
+    teardown:
+      run: "true"
+      timeout: 10s
+`)
+	step := workflow.Steps()[0]
+	if workflow.teardownTimeout.String() != "1m0s" || step.Teardown.Timeout.String() != "10s" {
+		t.Errorf("unexpected timeouts: %s and %s", workflow.teardownTimeout, step.Teardown.Timeout)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		line string
+	}{
+		{
+			"unknown field",
+			"target: local\ntargets: {local: 'local://'}\nstep: []",
+			"line 3",
+		},
+		{
+			"unknown step field",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    when: always",
+			"line 6",
+		},
+		{
+			"invalid timeout",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    timeout: soon",
+			"line 6",
+		},
+		{
+			"unknown default target",
+			"target: remote\ntargets: {local: 'local://'}",
+			"line 1",
+		},
+		{
+			"invalid target",
+			"targets:\n  local: local://\n  bad: 'nope://x'",
+			"line 3",
+		},
+		{
+			"invalid script",
+			"scripts:\n  a: {inline: 'true'}\n  b: {inline: 'true', file: b.sh}",
+			"line 3",
+		},
+		{
+			"invalid env",
+			"env:\n  - A=1\n  - B",
+			"line 3",
+		},
+		{
+			"reserved field",
+			"fields:\n  name: a\n  steps: b",
+			"line 3",
+		},
+		{
+			"invalid step name",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a-b\n    run: 'true'",
+			"line 4",
+		},
+		{
+			"duplicate step name",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n  - name: a\n    run: 'true'",
+			"line 6",
+		},
+		{
+			"unknown step target",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n  - name: b\n    run: 'true'\n    target: remote",
+			"line 6",
+		},
+		{
+			"no target",
+			"steps:\n  - name: a\n    run: 'true'",
+			"line 2",
+		},
+		{
+			"invalid step env",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n  - name: b\n    run: 'true'\n    env: [B]",
+			"line 6",
+		},
+		{
+			"invalid cmd",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    cmd: {echo: a}",
+			"line 5",
+		},
+		{
+			"unknown teardown field",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    teardown:\n      run: 'true'\n      when: always",
+			"line 8",
+		},
+		{
+			"invalid teardown",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    teardown: [a, b]",
+			"line 6",
+		},
+		{
+			"teardown without command",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n  - name: b\n    run: 'true'\n    teardown: {timeout: 1s}",
+			"line 6",
+		},
+		{
+			"teardown with multiple commands",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    teardown: {run: 'true', cmd: 'true'}",
+			"line 4",
+		},
+		{
+			"unknown teardown target",
+			"target: local\ntargets: {local: 'local://'}\nsteps:\n  - name: a\n    run: 'true'\n    teardown: {run: 'true', target: remote}",
+			"line 4",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := (Loader{FS: loadTestFS}).Load(context.Background(), []byte(test.raw))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), test.line+":") {
+				t.Errorf("expected an error for %s, got: %v", test.line, err)
+			}
+		})
+	}
+}
+
+func TestLoadFileScriptsWithoutFS(t *testing.T) {
+	_, err := (Loader{}).Load(context.Background(), []byte("target: local\ntargets: {local: 'local://'}\nscripts: {a: {file: a.sh}}\nsteps:\n  - name: a\n    script: a"))
+	if err == nil || !strings.Contains(err.Error(), "without a file system") {
+		t.Errorf("expected an error for a file script without a file system, got: %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/willfantom/nescript"
+)
+
+// Step is a single script/cmd of a workflow, executed via an executor. Exactly
+// one of Script or Cmd must be set.
+type Step struct {
+	// Name identifies the step, so that later steps can refer to its outputs,
+	// e.g. {{.steps.setup.outputs.port}}. Names must be unique within a
+	// workflow, and contain only letters, digits and underscores (not starting
+	// with a digit).
+	Name string
+
+	// Script is executed as the step (see nescript.Script.Cmd). Any timeout
+	// and outputs declared by the script's metadata are honoured.
+	Script *nescript.Script
+
+	// Cmd is executed as the step.
+	Cmd *nescript.Cmd
+
+	// Executor executes the step. If nil, the workflow's executor is used.
+	Executor nescript.ExecFunc
+
+	// If is an expression evaluated (using the workflow's evaluator) before
+	// the step is executed, where the step is skipped unless it evaluates to
+	// true. The expression can use the same data as the step's template, e.g.
+	// `steps.setup.outputs.port > 1024`. If empty, the step always runs.
+	If string
+
+	// Assert holds expressions that are evaluated (using the workflow's
+	// evaluator) against the outputs of the step once it has completed, where
+	// the step fails unless every expression evaluates to true, e.g.
+	// `latency < 100`.
+	Assert []string
+
+	// Timeout is the maximum time the step can take to execute, after which it
+	// is killed and considered failed. If 0, the timeout given by the script's
+	// metadata is used (if any).
+	Timeout time.Duration
+
+	// ContinueOnError allows the workflow to continue if the step fails.
+	ContinueOnError bool
+
+	// Teardown undoes the changes made by the step, such as removing a tc
+	// qdisc or stopping a background capture. If the step's process is started
+	// (even if the step then fails), its teardown is executed once the workflow
+	// completes, however it completes. Steps that fail before their process is
+	// started (e.g. their condition or template can not be evaluated) are not
+	// torn down.
+	Teardown *Teardown
+}
+
+// Teardown is the script/cmd that undoes the changes made by a step. Exactly
+// one of Script or Cmd must be set. Teardowns are executed in the reverse
+// order of their steps, and are given the same template data as their step
+// along with the step's own results, e.g. {{.steps.capture.outputs.pid}}.
+type Teardown struct {
+	// Script is executed as the teardown (see nescript.Script.Cmd). Any
+	// timeout declared by the script's metadata is honoured.
+	Script *nescript.Script
+
+	// Cmd is executed as the teardown.
+	Cmd *nescript.Cmd
+
+	// Executor executes the teardown. If nil, the step's executor is used.
+	Executor nescript.ExecFunc
+
+	// Timeout is the maximum time the teardown can take to execute, after
+	// which it is killed. If 0, the timeout given by the script's metadata is
+	// used (if any). The workflow's teardown timeout also applies.
+	Timeout time.Duration
+}
+
+// StepStatus is the outcome of a step.
+type StepStatus string
+
+const (
+	StepSucceeded StepStatus = "success"
+	StepFailed    StepStatus = "failure"
+	StepSkipped   StepStatus = "skipped"
+)
+
+var (
+	// stepNameRegex matches valid step names, which can be used as a field
+	// name in both templates and expressions.
+	stepNameRegex *regexp.Regexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// StepResult is the outcome of a single step of a workflow.
+type StepResult struct {
+	Name   string     `json:"name"`
+	Status StepStatus `json:"status"`
+
+	// Result is the result of the step's process, or nil if the step was
+	// skipped or failed before a result was obtained.
+	Result *nescript.Result `json:"result,omitempty"`
+
+	// Outputs are the outputs set by the step (see nescript.Result.CombinedOutput).
+	Outputs nescript.Output `json:"outputs,omitempty"`
+
+	// Err is the reason the step failed, if it did.
+	Err error `json:"-"`
+
+	// started is true if the step's process was started, thus it may need to
+	// be torn down.
+	started bool
+}
+
+// validate checks that the step can be executed.
+func (s Step) validate() error {
+	if !stepNameRegex.MatchString(s.Name) {
+		return fmt.Errorf("step name '%s' is invalid", s.Name)
+	}
+	if (s.Script == nil) == (s.Cmd == nil) {
+		return fmt.Errorf("step '%s' must have exactly one of a script or a cmd", s.Name)
+	}
+	if s.Teardown != nil && (s.Teardown.Script == nil) == (s.Teardown.Cmd == nil) {
+		return fmt.Errorf("teardown of step '%s' must have exactly one of a script or a cmd", s.Name)
+	}
+	return nil
+}
+
+// timeout returns the timeout of the step, falling back to that of the
+// script's metadata.
+func (s Step) timeout() time.Duration {
+	if s.Timeout == 0 && s.Script != nil && s.Script.Metadata() != nil {
+		return s.Script.Metadata().Timeout
+	}
+	return s.Timeout
+}
+
+// executor returns the executor of the step, falling back to the given
+// executor.
+func (s Step) executor(fallback nescript.ExecFunc) nescript.ExecFunc {
+	if s.Executor != nil {
+		return s.Executor
+	}
+	return fallback
+}
+
+// data returns the template data of the step's script/cmd.
+func (s Step) data() map[string]any {
+	if s.Script != nil {
+		return s.Script.Data()
+	}
+	return s.Cmd.Data()
+}
+
+// render compiles the step's script/cmd with the given data added.
+func (s Step) render(data map[string]any) (nescript.Cmd, error) {
+	if s.Script != nil {
+		scriptTemplate, err := s.Script.Template()
+		if err != nil {
+			return nescript.Cmd{}, err
+		}
+		compiled, err := scriptTemplate.Render(data)
+		if err != nil {
+			return nescript.Cmd{}, err
+		}
+		return compiled.Cmd(), nil
+	}
+	cmdTemplate, err := s.Cmd.Template()
+	if err != nil {
+		return nescript.Cmd{}, err
+	}
+	return cmdTemplate.Render(data)
+}
+
+// run executes the step with the given template data, waiting for it to
+// complete.
+func (s Step) run(ctx context.Context, executor nescript.ExecFunc, evaluator nescript.EvalFunc, data map[string]any) StepResult {
+	stepResult := StepResult{
+		Name:   s.Name,
+		Status: StepFailed,
+	}
+	if executor = s.executor(executor); executor == nil {
+		stepResult.Err = fmt.Errorf("no executor was given")
+		return stepResult
+	}
+	cmd, err := s.render(data)
+	if err != nil {
+		stepResult.Err = err
+		return stepResult
+	}
+	process, err := cmd.Exec(executor)
+	if err != nil {
+		stepResult.Err = err
+		return stepResult
+	}
+	defer process.Close()
+	stepResult.started = true
+	result, err := waitForResult(ctx, process, s.timeout())
+	if err != nil {
+		stepResult.Err = err
+		return stepResult
+	}
+	stepResult.Result = result
+	stepResult.Outputs = result.CombinedOutput()
+	if result.ExitCode != 0 {
+		stepResult.Err = fmt.Errorf("exited with code %d", result.ExitCode)
+		return stepResult
+	}
+	if s.Script != nil && s.Script.Metadata() != nil {
+		if err := s.Script.Metadata().ValidateOutput(stepResult.Outputs); err != nil {
+			stepResult.Err = err
+			return stepResult
+		}
+	}
+	for _, assertion := range s.Assert {
+		if evaluator == nil {
+			stepResult.Err = fmt.Errorf("no evaluator was given for the assertions")
+			return stepResult
+		}
+		if ok, err := stepResult.Outputs.Evaluate(evaluator, assertion); err != nil {
+			stepResult.Err = fmt.Errorf("failed to evaluate assertion '%s': %w", assertion, err)
+			return stepResult
+		} else if !ok {
+			stepResult.Err = fmt.Errorf("assertion '%s' does not hold", assertion)
+			return stepResult
+		}
+	}
+	stepResult.Status = StepSucceeded
+	return stepResult
+}
+
+// teardownStep returns the step's teardown as a step, such that it can be
+// rendered and executed in the same way.
+func (s Step) teardownStep() Step {
+	step := Step{
+		Name:     s.Name,
+		Script:   s.Teardown.Script,
+		Cmd:      s.Teardown.Cmd,
+		Executor: s.Teardown.Executor,
+		Timeout:  s.Teardown.Timeout,
+	}
+	if step.Executor == nil {
+		step.Executor = s.Executor
+	}
+	return step
+}
+
+// teardown returns the function that executes the step (which should be a
+// teardown step) with the given template data, waiting for it to complete.
+func (s Step) teardown(executor nescript.ExecFunc, data map[string]any) nescript.TeardownFunc {
+	return func(ctx context.Context) (*nescript.Result, error) {
+		if executor = s.executor(executor); executor == nil {
+			return nil, fmt.Errorf("no executor was given")
+		}
+		cmd, err := s.render(data)
+		if err != nil {
+			return nil, err
+		}
+		process, err := cmd.Exec(executor)
+		if err != nil {
+			return nil, err
+		}
+		defer process.Close()
+		return waitForResult(ctx, process, s.timeout())
+	}
+}
+
+// fields returns the data that later steps can use to refer to the step.
+func (sr StepResult) fields() map[string]any {
+	fields := map[string]any{
+		"status":  string(sr.Status),
+		"outputs": map[string]any(sr.Outputs),
+	}
+	if sr.Outputs == nil {
+		fields["outputs"] = map[string]any{}
+	}
+	if sr.Result != nil {
+		fields["exitCode"] = sr.Result.ExitCode
+	}
+	return fields
+}
+
+// waitForResult waits for the result of the process. If the timeout (when not
+// 0) is reached, or the context is cancelled first, the process is killed.
+func waitForResult(ctx context.Context, process nescript.Process, timeout time.Duration) (*nescript.Result, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return nescript.WaitContext(ctx, process)
+}
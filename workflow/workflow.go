@@ -0,0 +1,238 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/willfantom/nescript"
+	"github.com/willfantom/nescript/expr"
+)
+
+// Workflow is an ordered set of steps, where the outputs of earlier steps can
+// be used by the templates and conditions of later steps. For example, a step
+// named "setup" that sets the output "port" can be referred to by later steps
+// as {{.steps.setup.outputs.port}}. Each step's exit code and status
+// ("success", "failure" or "skipped") are also available, as
+// steps.<name>.exitCode and steps.<name>.status.
+type Workflow struct {
+	steps           []Step
+	executor        nescript.ExecFunc
+	evaluator       nescript.EvalFunc
+	fields          map[string]any
+	teardownTimeout time.Duration
+}
+
+// Result is the outcome of running a workflow, holding the result of every
+// step in order (including those that were skipped), and the result of every
+// teardown in the order they were executed.
+type Result struct {
+	Steps     []StepResult              `json:"steps"`
+	Teardowns []nescript.TeardownResult `json:"teardowns,omitempty"`
+}
+
+const (
+	// stepsField is the name of the template field holding the results of the
+	// steps that have already run.
+	stepsField string = "steps"
+)
+
+// NewWorkflow creates a workflow from the given steps, which are run in the
+// order given. By default, steps without an executor can not be run (see
+// WithExecutor), and conditions are evaluated using the expr package.
+func NewWorkflow(steps ...Step) *Workflow {
+	workflow := Workflow{
+		steps:     append([]Step{}, steps...),
+		evaluator: expr.EvalFunc(),
+		fields:    make(map[string]any),
+	}
+	return &workflow
+}
+
+// Steps returns the steps of the workflow, in order.
+func (w Workflow) Steps() []Step {
+	return append([]Step{}, w.steps...)
+}
+
+// WithStep adds a step to the end of the workflow.
+func (w Workflow) WithStep(step Step) Workflow {
+	w.steps = append(w.Steps(), step)
+	return w
+}
+
+// WithExecutor sets the executor used by steps that do not have their own.
+func (w Workflow) WithExecutor(executor nescript.ExecFunc) Workflow {
+	w.executor = executor
+	return w
+}
+
+// WithEvaluator sets the function used to evaluate the conditions of steps.
+func (w Workflow) WithEvaluator(evaluator nescript.EvalFunc) Workflow {
+	w.evaluator = evaluator
+	return w
+}
+
+// WithTeardownTimeout sets the maximum time each step's teardown can take to
+// execute, after which it is killed. By default, teardowns are not time
+// limited.
+func (w Workflow) WithTeardownTimeout(timeout time.Duration) Workflow {
+	w.teardownTimeout = timeout
+	return w
+}
+
+// WithFields adds fields that are available to the templates and conditions
+// of every step. If a step's script/cmd has a field of the same name, the
+// step's value is used. The field "steps" is reserved.
+func (w Workflow) WithFields(fields map[string]any) Workflow {
+	merged := make(map[string]any, len(w.fields)+len(fields))
+	for k, v := range w.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	w.fields = merged
+	return w
+}
+
+// Validate checks that every step of the workflow is valid, and that step
+// names are unique.
+func (w Workflow) Validate() error {
+	names := make(map[string]bool)
+	for _, step := range w.steps {
+		if err := step.validate(); err != nil {
+			return err
+		}
+		if names[step.Name] {
+			return fmt.Errorf("multiple steps named '%s'", step.Name)
+		}
+		names[step.Name] = true
+	}
+	if _, ok := w.fields[stepsField]; ok {
+		return fmt.Errorf("the field '%s' is reserved", stepsField)
+	}
+	return nil
+}
+
+// Run executes each step of the workflow in order, waiting for each to
+// complete before the next is started. A step fails if it can not be
+// executed, exits with a non-zero exit code, does not set the outputs declared
+// by its script's metadata, times out, or any of its assertions do not hold.
+// Unless the step has ContinueOnError set, the remaining steps are then skipped
+// and an error is returned along with the result. If the context is
+// cancelled, the running step is killed and the remaining steps are skipped.
+// Once the steps are complete (or a step panics), the teardowns of the steps
+// whose process was started are executed in reverse order, and their results are
+// given in the result. A teardown failing does not cause an error to be
+// returned. This errors without running any step if the workflow is invalid.
+func (w Workflow) Run(ctx context.Context) (*Result, error) {
+	if err := w.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid workflow: %w", err)
+	}
+	result := Result{
+		Steps: make([]StepResult, 0, len(w.steps)),
+	}
+	teardowns, err := nescript.RunScope(ctx, w.teardownTimeout, func(ctx context.Context, scope *nescript.Scope) error {
+		return w.runSteps(ctx, scope, &result)
+	})
+	result.Teardowns = teardowns
+	return &result, err
+}
+
+// runSteps executes each step in order, adding the results to the workflow's
+// result and registering the teardowns of started steps with the scope.
+func (w Workflow) runSteps(ctx context.Context, scope *nescript.Scope, result *Result) error {
+	steps := make(map[string]any)
+	var failure error
+	for _, step := range w.steps {
+		if failure == nil && ctx.Err() != nil {
+			failure = fmt.Errorf("workflow was cancelled: %w", ctx.Err())
+		}
+		stepResult := StepResult{
+			Name:   step.Name,
+			Status: StepSkipped,
+		}
+		if failure == nil {
+			stepResult = w.runStep(ctx, step, steps)
+		}
+		steps[step.Name] = stepResult.fields()
+		result.Steps = append(result.Steps, stepResult)
+		if step.Teardown != nil && stepResult.started {
+			teardown := step.teardownStep()
+			scope.DeferFunc(step.Name, teardown.teardown(w.executor, w.data(teardown, copyMap(steps))))
+		}
+		if failure == nil && stepResult.Status == StepFailed && !step.ContinueOnError {
+			failure = fmt.Errorf("step '%s' failed: %w", step.Name, stepResult.Err)
+		}
+	}
+	return failure
+}
+
+// runStep evaluates the condition of the step, then runs it if the condition
+// holds.
+func (w Workflow) runStep(ctx context.Context, step Step, steps map[string]any) StepResult {
+	data := w.data(step, steps)
+	if step.If != "" {
+		if w.evaluator == nil {
+			return StepResult{Name: step.Name, Status: StepFailed, Err: fmt.Errorf("no evaluator was given for the condition")}
+		}
+		conditionData := nescript.Output{}
+		for k, v := range step.data() {
+			conditionData[k] = v
+		}
+		for k, v := range data {
+			conditionData[k] = v
+		}
+		ok, err := w.evaluator(conditionData, step.If)
+		if err != nil {
+			return StepResult{Name: step.Name, Status: StepFailed, Err: fmt.Errorf("failed to evaluate condition: %w", err)}
+		}
+		if !ok {
+			return StepResult{Name: step.Name, Status: StepSkipped}
+		}
+	}
+	return step.run(ctx, w.executor, w.evaluator, data)
+}
+
+// data returns the data given to the step, made up of the workflow's fields
+// (excluding those the step already has) and the results of previous steps.
+func (w Workflow) data(step Step, steps map[string]any) map[string]any {
+	stepData := step.data()
+	data := make(map[string]any, len(w.fields)+1)
+	for k, v := range w.fields {
+		if _, ok := stepData[k]; !ok {
+			data[k] = v
+		}
+	}
+	data[stepsField] = steps
+	return data
+}
+
+// copyMap returns a shallow copy of the map.
+func copyMap(m map[string]any) map[string]any {
+	copied := make(map[string]any, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Step returns the result of the step with the given name, if it exists.
+func (r Result) Step(name string) (*StepResult, bool) {
+	for idx := range r.Steps {
+		if r.Steps[idx].Name == name {
+			return &r.Steps[idx], true
+		}
+	}
+	return nil, false
+}
+
+// Succeeded determines if no step of the workflow failed.
+func (r Result) Succeeded() bool {
+	for _, step := range r.Steps {
+		if step.Status == StepFailed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,295 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/willfantom/nescript"
+	"github.com/willfantom/nescript/dryrun"
+	"github.com/willfantom/nescript/local"
+)
+
+// scriptStep returns a step that runs the script.
+func scriptStep(name, raw string) Step {
+	return Step{Name: name, Script: nescript.NewScript(raw)}
+}
+
+// cmdStep returns a step that runs the cmd.
+func cmdStep(name string, cmd nescript.Cmd) Step {
+	return Step{Name: name, Cmd: &cmd}
+}
+
+// runLocal runs the workflow of the given steps with the local executor.
+func runLocal(t *testing.T, steps ...Step) (*Result, error) {
+	t.Helper()
+	workflow := NewWorkflow(steps...).WithExecutor(local.Executor(""))
+	return workflow.Run(context.Background())
+}
+
+// stepResult returns the result of the named step, failing the test if there
+// is none.
+func stepResult(t *testing.T, result *Result, name string) *StepResult {
+	t.Helper()
+	stepResult, ok := result.Step(name)
+	if !ok {
+		t.Fatalf("no result for step '%s'", name)
+	}
+	return stepResult
+}
+
+// statuses returns the status of each step of the result, in order.
+func statuses(result *Result) []StepStatus {
+	statuses := make([]StepStatus, len(result.Steps))
+	for idx, step := range result.Steps {
+		statuses[idx] = step.Status
+	}
+	return statuses
+}
+
+func TestStepOutputs(t *testing.T) {
+	result, err := runLocal(t,
+		scriptStep("setup", `echo "::set-output name=port type=int::8080"; echo "::set-output name=host::node 1"`),
+		scriptStep("use", `echo "{{.steps.setup.outputs.host}}:{{.steps.setup.outputs.port}} {{.steps.setup.status}} {{.steps.setup.exitCode}}"`),
+		scriptStep("chain", `echo "::set-output name=next::{{.steps.setup.outputs.port}}1"`),
+		scriptStep("last", `echo "{{.steps.chain.outputs.next}}"`),
+	)
+	if err != nil {
+		t.Fatalf("failed to run workflow: %v", err)
+	}
+	setup := stepResult(t, result, "setup")
+	if setup.Outputs["port"] != 8080 || setup.Outputs["host"] != "node 1" {
+		t.Errorf("unexpected outputs: %v", setup.Outputs)
+	}
+	if stdout := stepResult(t, result, "use").Result.StdOut; stdout != "node 1:8080 success 0\n" {
+		t.Errorf("outputs were not passed to a later step: %q", stdout)
+	}
+	if stdout := stepResult(t, result, "last").Result.StdOut; stdout != "80801\n" {
+		t.Errorf("outputs were not passed through steps: %q", stdout)
+	}
+}
+
+func TestStepWorkflowFields(t *testing.T) {
+	script := nescript.NewScript(`echo "{{.greeting}} {{.name}}"`).WithField("name", "step")
+	workflow := NewWorkflow(Step{Name: "greet", Script: &script}).
+		WithExecutor(local.Executor("")).
+		WithFields(map[string]any{"greeting": "hello", "name": "workflow"})
+	result, err := workflow.Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run workflow: %v", err)
+	}
+	if stdout := stepResult(t, result, "greet").Result.StdOut; stdout != "hello step\n" {
+		t.Errorf("unexpected stdout: %q", stdout)
+	}
+	if _, err := workflow.WithFields(map[string]any{"steps": 1}).Run(context.Background()); err == nil {
+		t.Error("expected an error for the reserved steps field")
+	}
+}
+
+func TestStepConditions(t *testing.T) {
+	high := scriptStep("high", "echo high")
+	high.If = "steps.setup.outputs.port > 1024"
+	low := scriptStep("low", "echo low")
+	low.If = "steps.setup.outputs.port <= 1024"
+	afterSkip := scriptStep("after_skip", "echo after")
+	afterSkip.If = `steps.low.status == "skipped" && steps.high.exitCode == 0`
+	invalid := scriptStep("invalid", "echo invalid")
+	invalid.If = "steps.setup.outputs.port >"
+	result, err := runLocal(t,
+		scriptStep("setup", `echo "::set-output name=port type=int::8080"`),
+		high,
+		low,
+		afterSkip,
+		invalid,
+	)
+	if err == nil {
+		t.Error("expected an error for the invalid condition")
+	}
+	expected := []StepStatus{StepSucceeded, StepSucceeded, StepSkipped, StepSucceeded, StepFailed}
+	if got := statuses(result); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected statuses %v, got %v", expected, got)
+	}
+	if stepResult(t, result, "low").Result != nil {
+		t.Error("expected the skipped step to not be executed")
+	}
+	if stdout := stepResult(t, result, "high").Result.StdOut; stdout != "high\n" {
+		t.Errorf("unexpected stdout: %q", stdout)
+	}
+}
+
+func TestStepFailures(t *testing.T) {
+	tests := []struct {
+		name            string
+		step            Step
+		continueOnError bool
+		statuses        []StepStatus
+		err             bool
+	}{
+		{"exit code", scriptStep("step", "exit 3"), false, []StepStatus{StepFailed, StepSkipped}, true},
+		{"exit code continued", scriptStep("step", "exit 3"), true, []StepStatus{StepFailed, StepSucceeded}, false},
+		{"render", cmdStep("step", nescript.NewCmd("echo", "{{.missing}}").WithStrict(true)), false, []StepStatus{StepFailed, StepSkipped}, true},
+		{"render continued", cmdStep("step", nescript.NewCmd("echo", "{{.missing}}").WithStrict(true)), true, []StepStatus{StepFailed, StepSucceeded}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.step.ContinueOnError = test.continueOnError
+			result, err := runLocal(t, test.step, scriptStep("after", `echo "{{.steps.step.status}}"`))
+			if test.err != (err != nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if got := statuses(result); !reflect.DeepEqual(got, test.statuses) {
+				t.Fatalf("expected statuses %v, got %v", test.statuses, got)
+			}
+			if result.Succeeded() {
+				t.Error("expected the result to not have succeeded")
+			}
+			if test.continueOnError {
+				if stdout := stepResult(t, result, "after").Result.StdOut; stdout != "failure\n" {
+					t.Errorf("unexpected stdout: %q", stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestStepContinueOnErrorFailure(t *testing.T) {
+	failing := scriptStep("failing", "exit 1")
+	failing.ContinueOnError = true
+	result, err := runLocal(t, failing, scriptStep("second", "exit 2"), scriptStep("third", "true"))
+	if err == nil || !strings.Contains(err.Error(), "second") {
+		t.Errorf("expected an error for the second step, got: %v", err)
+	}
+	expected := []StepStatus{StepFailed, StepFailed, StepSkipped}
+	if got := statuses(result); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected statuses %v, got %v", expected, got)
+	}
+	if exitCode := stepResult(t, result, "second").Result.ExitCode; exitCode != 2 {
+		t.Errorf("unexpected exit code: %d", exitCode)
+	}
+}
+
+func TestStepAssertions(t *testing.T) {
+	const script string = `echo "::set-output name=latency type=int::42"`
+	tests := []struct {
+		name   string
+		assert []string
+		status StepStatus
+		err    string
+	}{
+		{"none", nil, StepSucceeded, ""},
+		{"holds", []string{"latency < 100"}, StepSucceeded, ""},
+		{"all hold", []string{"latency < 100", "latency > 10"}, StepSucceeded, ""},
+		{"does not hold", []string{"latency < 100", "latency < 10"}, StepFailed, "assertion 'latency < 10' does not hold"},
+		{"invalid", []string{"latency <"}, StepFailed, "failed to evaluate assertion"},
+		{"missing output", []string{"jitter < 10"}, StepFailed, "failed to evaluate assertion"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step := scriptStep("measure", script)
+			step.Assert = test.assert
+			result, err := runLocal(t, step)
+			measure := stepResult(t, result, "measure")
+			if measure.Status != test.status {
+				t.Fatalf("expected status %s, got %s (%v)", test.status, measure.Status, measure.Err)
+			}
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || measure.Err == nil || !strings.Contains(measure.Err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, measure.Err)
+			}
+			if measure.Outputs["latency"] != 42 {
+				t.Errorf("expected the outputs of a failed assertion to be kept, got %v", measure.Outputs)
+			}
+		})
+	}
+}
+
+func TestStepDeclaredOutputs(t *testing.T) {
+	const frontMatter string = "# ---\n# outputs:\n#   port: {type: int}\n#   host: {type: string}\n# ---\n"
+	tests := []struct {
+		name   string
+		body   string
+		status StepStatus
+		err    string
+	}{
+		{"set", `echo "::set-output name=port type=int::80"; echo "::set-output name=host::a"`, StepSucceeded, ""},
+		{"missing", `echo "::set-output name=port type=int::80"`, StepFailed, "expected output 'host' was not set"},
+		{"wrong type", `echo "::set-output name=port::eighty"; echo "::set-output name=host::a"`, StepFailed, "output 'port' is not an int"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := nescript.ParseScript(frontMatter + test.body)
+			if err != nil {
+				t.Fatalf("failed to parse script: %v", err)
+			}
+			result, err := runLocal(t, Step{Name: "setup", Script: script}, scriptStep("after", "true"))
+			setup := stepResult(t, result, "setup")
+			if setup.Status != test.status {
+				t.Fatalf("expected status %s, got %s (%v)", test.status, setup.Status, setup.Err)
+			}
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || setup.Err == nil || !strings.Contains(setup.Err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got: %v", test.err, setup.Err)
+			}
+			if status := stepResult(t, result, "after").Status; status != StepSkipped {
+				t.Errorf("expected the later step to be skipped, got %s", status)
+			}
+		})
+	}
+}
+
+func TestTeardownsOnlyRunForStartedSteps(t *testing.T) {
+	recorder := &dryrun.Recorder{}
+	executor := dryrun.Executor(recorder, "test")
+	failing := func(nescript.Cmd) (nescript.Process, error) {
+		return nil, errors.New("target unreachable")
+	}
+	step := func(name string, cmd nescript.Cmd, exec nescript.ExecFunc) Step {
+		return Step{
+			Name:            name,
+			Cmd:             &cmd,
+			Executor:        exec,
+			ContinueOnError: true,
+			Teardown:        &Teardown{Cmd: nescript.NewCmd("undo", name), Executor: executor},
+		}
+	}
+	condition := step("condition", *nescript.NewCmd("true"), executor)
+	condition.If = "missing("
+	skipped := step("skipped", *nescript.NewCmd("true"), executor)
+	skipped.If = "false"
+	workflow := NewWorkflow(
+		condition,
+		skipped,
+		step("render", nescript.NewCmd("echo", "{{.missing}}").WithStrict(true), executor),
+		step("executor", *nescript.NewCmd("true"), nil),
+		step("exec", *nescript.NewCmd("true"), failing),
+		step("started", *nescript.NewCmd("true"), executor),
+	)
+	result, err := workflow.Run(context.Background())
+	if err != nil {
+		t.Fatalf("failed to run workflow: %v", err)
+	}
+	for _, stepResult := range result.Steps {
+		if expected := (stepResult.Name == "skipped"); (stepResult.Status == StepSkipped) != expected {
+			t.Errorf("step '%s' has status %s", stepResult.Name, stepResult.Status)
+		}
+	}
+	if len(result.Teardowns) != 1 || result.Teardowns[0].Name != "started" {
+		t.Fatalf("unexpected teardowns: %+v", result.Teardowns)
+	}
+	records := recorder.Records()
+	if last := records[len(records)-1]; len(last.Argv) != 2 || last.Argv[1] != "started" {
+		t.Errorf("unexpected teardown record: %+v", last)
+	}
+}